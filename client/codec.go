@@ -0,0 +1,78 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// ClientCodec translates between the wire protocol of a client and V5 frames
+//
+// ClientSolarman speaks V5 natively. Other frontends (e.g. Modbus TCP) provide their
+// own codec, the rest of the routing stays the same.
+type ClientCodec interface {
+	// ReadFrame blocks until the next client request is available and returns it as V5 frame
+	ReadFrame() (*protocol.V5Frame, error)
+	// WriteFrame converts a V5 frame from the logger and writes it to the client
+	WriteFrame(data []byte) error
+}
+
+// v5Codec pass-through codec for V5 clients
+type v5Codec struct {
+	conn   net.Conn
+	reader *protocol.FrameReader
+}
+
+func newV5Codec(conn net.Conn) *v5Codec {
+	return &v5Codec{
+		conn:   conn,
+		reader: protocol.NewFrameReader(conn),
+	}
+}
+
+func (c *v5Codec) ReadFrame() (*protocol.V5Frame, error) {
+	return c.reader.ReadFrame()
+}
+
+func (c *v5Codec) WriteFrame(data []byte) error {
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// fixedSerialCodec V5 codec for clients which do not know the logger serial
+//
+// The serial of the requests is replaced with the serial of the logger, the responses
+// carry the serial used by the client
+type fixedSerialCodec struct {
+	*v5Codec
+	serial uint32
+	// serial of the last request from the client
+	clientSerial atomic.Uint32
+}
+
+// NewFixedSerialClient - Initializes a V5 client bound to the logger with the given serial
+// regardless of the serial in its requests
+func NewFixedSerialClient(conn net.Conn, serial uint32, serialRcv chan *CommSolarman, broadcast chan []byte) *ClientSolarman {
+	codec := &fixedSerialCodec{v5Codec: newV5Codec(conn), serial: serial}
+	return NewCodecClient(conn, codec, serialRcv, broadcast)
+}
+
+func (c *fixedSerialCodec) ReadFrame() (*protocol.V5Frame, error) {
+	frame, err := c.v5Codec.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	c.clientSerial.Store(frame.LoggerSN())
+	frame.SetLoggerSN(c.serial)
+	return frame, nil
+}
+
+func (c *fixedSerialCodec) WriteFrame(data []byte) error {
+	frame, err := protocol.NewV5Frame(data)
+	if err != nil {
+		return c.v5Codec.WriteFrame(data)
+	}
+	frame.SetLoggerSN(c.clientSerial.Load())
+	return c.v5Codec.WriteFrame(frame.Bytes())
+}
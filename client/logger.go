@@ -5,15 +5,12 @@ import (
 	log "github.com/githubDante/go-solarman-proxy/logging"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/githubDante/go-solarman-proxy/protocol"
 )
 
-const (
-	writeTimeout = 200 * time.Millisecond // Deadline for socket write operations
-)
-
 type loggerBuffer struct {
 	logger *ClientSolarman
 	buf    []byte
@@ -28,13 +25,27 @@ type ClientLogger struct {
 	lock    sync.Mutex
 	// Reporting channel for serial numbers
 	SReporter chan *CommLogger
+	// Reporting channel for data uploads (optional)
+	DataReporter chan *protocol.DataUpload
 	// Reporting channel on socket disconnection
-	stoppedCh      chan *CommLogger
-	Running        bool
-	Id             uint32
+	stoppedCh chan *CommLogger
+	running   atomic.Bool
+	Id        uint32
+	// Write buffer. bufferLock guards waitingForData and dataBuffer
+	bufferLock     sync.Mutex
 	waitingForData bool
 	dataBuffer     []*loggerBuffer
-	bufferWanted   bool
+	bufferWanted   atomic.Bool
+	strict         bool
+	counters       frameCounters
+	requests       requestTracker
+	// Serial probe sent, the response is consumed by the proxy
+	probing atomic.Bool
+	// Serial used in the probe request (0 - unknown)
+	probeSerial uint32
+	// Cloud tee (optional), used by the read loop only
+	upstreamRoute *UpstreamRoute
+	upstream      *upstream
 }
 
 // NewLoggerClient - Initializes a new data-logger client
@@ -49,7 +60,6 @@ func NewLoggerClient(conn net.Conn, serialRcv chan *CommLogger, disconnectChan c
 		Clients:   make(map[uint32]*ClientSolarman),
 		lock:      sync.Mutex{},
 		SReporter: serialRcv,
-		Running:   false,
 		Id:        nextId(),
 		stoppedCh: disconnectChan,
 	}
@@ -61,48 +71,70 @@ type CommLogger struct {
 }
 
 func (c *ClientLogger) Run() {
-	c.Running = true
+	c.running.Store(true)
 	defer func() {
-		c.Running = false
+		c.running.Store(false)
+		c.stopUpstream()
 		if c.stoppedCh != nil {
-			c.stoppedCh <- &CommLogger{Serial: c.Id, Logger: c}
+			c.stoppedCh <- &CommLogger{Serial: c.Serial, Logger: c}
 		}
 	}()
 	c.serialProbe() // probe for serial on connect
+	reader := protocol.NewFrameReader(c.Conn)
 	for {
-		buffer := make([]byte, 2048)
-		//time.Sleep(200 * time.Millisecond)
 		log.LogDebugf("Logger <%p> waiting for data...\n", c)
-		pLen, err := c.Conn.Read(buffer)
+		packet, err := reader.ReadFrame()
 		if err != nil {
-			//fmt.Fprintf(os.Stdout, "Logger <%d> [%s] connection closed?!?\n", c.Serial, c.Conn.RemoteAddr().String())
-			log.LogErrorf("<%d> Err?!? - %s\n", pLen, err.Error())
+			log.LogErrorf("Logger <%p> read error [%d bytes pending] - %s\n", c, reader.Buffered(), err.Error())
 			c.Conn.Close()
 			return
 		}
-		if c.Serial == 0 {
-			packet, err := protocol.NewV5Frame(buffer[:pLen])
-			if err == nil {
-				c.Serial = packet.LoggerSN()
-				log.LogDebugf("Logger <%s> provided SN [%d]\n", c.Conn.RemoteAddr().String(), c.Serial)
-				c.SReporter <- &CommLogger{Serial: c.Serial, Logger: c}
-				time.Sleep(10 * time.Millisecond)
-			} else {
-				log.LogErrorf("Bad packet from logger <%p>. Cannot create V5 frame from: %s\n",
-					c, hex.Dump(buffer[:pLen]))
+		c.counters.received()
+		if c.strict {
+			if err := packet.Validate(); err != nil {
+				dropped := c.counters.dropped(dropReason(err))
+				log.LogWarnf("Logger <%p> frame dropped [%s]. Dropped frames [%d]\n", c, err.Error(), dropped)
+				log.LogDebugf("Logger <%p> dropped frame: %s\n", c, hex.EncodeToString(packet.Bytes()))
 				continue
 			}
 		}
+		if c.Serial == 0 {
+			c.Serial = packet.LoggerSN()
+			log.LogDebugf("Logger <%s> provided SN [%d]\n", c.Conn.RemoteAddr().String(), c.Serial)
+			c.SReporter <- &CommLogger{Serial: c.Serial, Logger: c}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if packet.IsLoggerInitiated() {
+			if !c.teeUpstream(packet) {
+				c.reply(packet)
+			}
+			if packet.Control() == protocol.ControlData {
+				c.captureData(packet)
+			}
+			continue
+		}
 
-		c.waitingForData = false
-		go c.sendToAll(buffer[:pLen])
+		c.dispatch(packet)
 	}
 }
 
+// SetProbeSerial sets the serial number used by the serial probe
+//
+// Loggers in TCP-server mode ignore requests which are not addressed to their own serial
+func (c *ClientLogger) SetProbeSerial(serial uint32) {
+	c.probeSerial = serial
+}
+
+// Running reports whether the logger read loop is active
+func (c *ClientLogger) Running() bool {
+	return c.running.Load()
+}
+
 // Stop will close the logger socket
 func (c *ClientLogger) Stop() {
 	c.Conn.SetDeadline(time.Now().Add(5 * time.Millisecond))
-	if !c.Running {
+	if !c.Running() {
 		_ = c.Conn.Close()
 	} else {
 		_, _ = c.Conn.Write([]byte{0})
@@ -112,10 +144,69 @@ func (c *ClientLogger) Stop() {
 
 // EnableBuffering activates the logger write buffer. All messages will be sent sequentially
 //
-// The responses from the logger are still broadcasted to all clients
+// The responses from the logger are still routed to the clients which sent the requests
 func (c *ClientLogger) EnableBuffering() {
-	log.LogDebugf("Logger <%p> write buffer activated.\n", c)
-	c.bufferWanted = true
+	c.SetBuffering(true)
+}
+
+// SetBuffering activates or deactivates the logger write buffer. Safe to call at runtime,
+// the already buffered requests are still sent
+func (c *ClientLogger) SetBuffering(enabled bool) {
+	log.LogDebugf("Logger <%p> write buffer active [%t].\n", c, enabled)
+	c.bufferWanted.Store(enabled)
+}
+
+// EnableStrict activates strict frame validation. Invalid frames from the logger are dropped
+func (c *ClientLogger) EnableStrict() {
+	log.LogDebugf("Logger <%p> strict frame validation activated.\n", c)
+	c.strict = true
+}
+
+// Stats frame counters of the logger connection
+func (c *ClientLogger) Stats() FrameStats {
+	return c.counters.snapshot()
+}
+
+// dispatch delivers a frame received from the logger
+//
+// Responses are sent only to the client which sent the request, everything else
+// (unsolicited frames, responses without known requester) is broadcast to all clients
+func (c *ClientLogger) dispatch(packet *protocol.V5Frame) {
+	var req *outstandingRequest
+	if packet.Control() == protocol.ControlResponse {
+		if packet.RequestSequence() == byte(SerialProbe.Sequence) && c.probing.CompareAndSwap(true, false) {
+			log.LogDebugf("Logger <%p> serial probe response consumed\n", c)
+			return
+		}
+		req = c.requests.take(packet.RequestSequence())
+	}
+	if req != nil {
+		packet.SetRequestSequence(req.seq) // restore the sequence number and the serial used by the client
+		if packet.LoggerSN() != req.serial {
+			packet.SetLoggerSN(req.serial)
+		}
+		c.sendToClient(req.client, packet.Bytes())
+	} else {
+		c.sendToAll(packet.Bytes())
+	}
+
+	if buf := c.getFromBuffer(); buf != nil {
+		c.write(buf.buf, buf.logger)
+	}
+}
+
+// sendToClient delivers a response to the client which requested it
+func (c *ClientLogger) sendToClient(cl *ClientSolarman, data []byte) {
+	if !cl.Running() {
+		log.LogDebugf("Logger <%p> requester <%p> disconnected. Response dropped.\n", c, cl)
+		return
+	}
+	err := cl.Send(data)
+	if err != nil {
+		log.LogWarnf("Client <%p> marked as disconnected\n", cl)
+		return
+	}
+	log.LogDebugf("Logger <%p> response sent to <%p>: %s\n", c, cl, hex.EncodeToString(data))
 }
 
 // sendToAll packet broadcast to all connected clients
@@ -126,7 +217,7 @@ func (c *ClientLogger) sendToAll(data []byte) {
 	for _, cl := range c.Clients {
 		// Send to all clients
 		//_, err := cl.Conn.Write(data)
-		if cl.Running {
+		if cl.Running() {
 			err := cl.Send(data)
 			if err != nil {
 				log.LogWarnf("Client <%p> marked as disconnected\n", cl)
@@ -143,13 +234,6 @@ func (c *ClientLogger) sendToAll(data []byte) {
 
 	log.LogDebugf("Logger <%p> data sent to all [%d] clients...\n", c, len(c.Clients))
 	log.LogDebugf("Logger <%p> data: %s\n", c, hex.EncodeToString(data))
-
-	if c.pendingInBuffer() {
-		buf := c.getFromBuffer()
-		if buf != nil {
-			c.Send(buf.buf, buf.logger)
-		}
-	}
 }
 
 func (c *ClientLogger) Add(cl *ClientSolarman) {
@@ -159,28 +243,66 @@ func (c *ClientLogger) Add(cl *ClientSolarman) {
 	c.Clients[cl.Id] = cl
 }
 
+// Remove drops the association with the client
+func (c *ClientLogger) Remove(cl *ClientSolarman) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.Clients, cl.Id)
+}
+
+// Associated the clients currently associated with the logger
+func (c *ClientLogger) Associated() []*ClientSolarman {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	clients := make([]*ClientSolarman, 0, len(c.Clients))
+	for _, cl := range c.Clients {
+		clients = append(clients, cl)
+	}
+	return clients
+}
+
 // Send will send data to the logger
 //
-// Extra operations can be performed too. Currently only logging
+// The request sequence number is replaced with a value unique for the logger and registered,
+// so the response can be routed back to the sender with its original sequence number.
+// Requests with serial other than the logger's own get the logger serial (restored in the response)
 func (c *ClientLogger) Send(data []byte, from *ClientSolarman) {
-	if !c.Running {
+	if !c.Running() {
 		return
 	}
-	if c.waitingForData && c.bufferWanted {
+	c.bufferLock.Lock()
+	if c.waitingForData && c.bufferWanted.Load() {
 		c.addToBuffer(data, from)
+		c.bufferLock.Unlock()
 		return
 	}
-	log.LogDebugf("Logger <%p> sending data from <%p>\n", c, from)
-	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 	c.waitingForData = true
+	c.bufferLock.Unlock()
+	c.write(data, from)
+}
+
+// write registers the request and writes it to the logger
+func (c *ClientLogger) write(data []byte, from *ClientSolarman) {
+	log.LogDebugf("Logger <%p> sending data from <%p>\n", c, from)
+	if packet, err := protocol.NewV5Frame(data); err == nil && from != nil {
+		proxySeq := c.requests.add(packet.RequestSequence(), packet.LoggerSN(), from)
+		log.LogDebugf("Logger <%p> request sequence [%d] -> [%d]\n", c, packet.RequestSequence(), proxySeq)
+		packet.SetRequestSequence(proxySeq)
+		if c.Serial != 0 && packet.LoggerSN() != c.Serial {
+			// e.g. client with serial 0 routed to a default logger
+			packet.SetLoggerSN(c.Serial)
+		}
+		data = packet.Bytes()
+	}
+	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout()))
 	_, err := c.Conn.Write(data)
 	if err != nil {
 		log.LogErrorf("Cannot communicate with logger <%p>\n", c)
 		log.LogWarnf("Logger <%p> will be disconnected!\n", c)
 		c.Stop()
 	} else {
-		if c.bufferWanted {
-			log.LogInfof("Logger <%p> sending complete. Waiting for data [%t]\n", c, c.waitingForData)
+		if c.bufferWanted.Load() {
+			log.LogInfof("Logger <%p> sending complete. Waiting for data\n", c)
 		}
 	}
 
@@ -188,6 +310,8 @@ func (c *ClientLogger) Send(data []byte, from *ClientSolarman) {
 
 // DumpClients drops all ClientSolarman instances associated with the logger and returns them as a slice
 func (c *ClientLogger) DumpClients() []*ClientSolarman {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	clients := make([]*ClientSolarman, 0)
 	for _, cl := range c.Clients {
 		clients = append(clients, cl)
@@ -196,10 +320,38 @@ func (c *ClientLogger) DumpClients() []*ClientSolarman {
 	return clients
 }
 
+// reply answers logger initiated frames (handshake, data, heartbeat...) on behalf of the cloud
+//
+// Some loggers reboot when such frames are left without reply
+func (c *ClientLogger) reply(packet *protocol.V5Frame) {
+	log.LogDebugf("Logger <%p> sent %s frame [%d bytes]. Replying.\n", c, packet.Control(), packet.Length())
+	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout()))
+	_, err := c.Conn.Write(protocol.TimeResponse(packet, time.Now()))
+	if err != nil {
+		log.LogErrorf("Cannot reply to logger <%p> %s frame: %s\n", c, packet.Control(), err.Error())
+	}
+}
+
+// captureData decodes a data upload and reports it
+func (c *ClientLogger) captureData(packet *protocol.V5Frame) {
+	data, err := protocol.ParseDataUpload(packet, time.Now())
+	if err != nil {
+		log.LogWarnf("Logger <%p> bad data upload: %s\n", c, err.Error())
+		return
+	}
+	log.LogInfof("Logger [%d] data upload [%d bytes]\n", data.Serial, len(data.Data))
+	if c.DataReporter != nil {
+		c.DataReporter <- data
+	}
+}
+
 // serialProbe send a predefined packet to the datalogger in order to acquire the serial number
 func (c *ClientLogger) serialProbe() {
-	probe := ReadHolding
-	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	probe := SerialProbe
+	probe.Serial = c.probeSerial
+	log.LogDebugf("Logger <%p> serial probe: %s\n", c, hex.EncodeToString(probe.RTU()))
+	c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout()))
+	c.probing.Store(true)
 	_, err := c.Conn.Write(probe.ToBytes())
 	if err != nil {
 		log.LogErrorf("SerialProbe failed, Cannot communicate with logger <%p>\n", c)
@@ -208,13 +360,19 @@ func (c *ClientLogger) serialProbe() {
 	}
 }
 
+// addToBuffer must be called with bufferLock held
 func (c *ClientLogger) addToBuffer(buffer []byte, logger *ClientSolarman) {
 	log.LogDebugf("Logger <%p> sending [%d bytes] to write buffer.\n", c, len(buffer))
 	c.dataBuffer = append(c.dataBuffer, &loggerBuffer{logger: logger, buf: buffer})
 }
 
+// getFromBuffer pops the next buffered request. With an empty buffer the logger is no longer
+// waiting for data, the next request is sent directly
 func (c *ClientLogger) getFromBuffer() *loggerBuffer {
+	c.bufferLock.Lock()
+	defer c.bufferLock.Unlock()
 	if len(c.dataBuffer) == 0 {
+		c.waitingForData = false
 		return nil
 	}
 	log.LogDebugf("Logger <%p> write buffer len [%d].\n", c, len(c.dataBuffer))
@@ -224,7 +382,3 @@ func (c *ClientLogger) getFromBuffer() *loggerBuffer {
 		c, len(top.buf), len(c.dataBuffer))
 	return top
 }
-
-func (c *ClientLogger) pendingInBuffer() bool {
-	return len(c.dataBuffer) > 0
-}
@@ -3,8 +3,21 @@ package client
 import (
 	"encoding/hex"
 	"strings"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
 )
 
+// SerialProbe request sent to every data-logger on connect in order to acquire its serial number
+//
+// The serial in the request is 0, so the logger responds with its own. The register
+// should exist on the inverter, otherwise some loggers never respond.
+var SerialProbe = protocol.ModbusRequest{
+	SlaveID:  1,
+	Function: protocol.FuncReadHoldingRegisters,
+	Address:  1,
+	Quantity: 1,
+}
+
 /*
 POC
 #1 ping packet
@@ -29,6 +42,9 @@ Notes:
 #2 it works - different functions can be used
 */
 
+// V5Payload predefined V5 request frame (hex encoded)
+//
+// Deprecated: use protocol.ModbusRequest which generates frames for any serial, sequence and register range
 type V5Payload string
 
 const (
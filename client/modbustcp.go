@@ -0,0 +1,121 @@
+package client
+
+import (
+	"net"
+	"sync"
+
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// defaultSlaveID slave id of the inverter behind the data-logger
+const defaultSlaveID byte = 1
+
+// ModbusRoute target data-logger resolution for clients not sending serial numbers
+type ModbusRoute struct {
+	// Fixed data-logger serial. The unit id from the client is used as Modbus slave id
+	Serial uint32
+	// Unit id to data-logger serial. Used when Serial is 0, the requests go to slave 1
+	Units map[byte]uint32
+}
+
+// resolve returns the logger serial and the slave id for the given unit id
+func (r *ModbusRoute) resolve(unit byte) (uint32, byte, bool) {
+	if r.Serial != 0 {
+		return r.Serial, unit, true
+	}
+	serial, ok := r.Units[unit]
+	return serial, defaultSlaveID, ok
+}
+
+type mbapRequest struct {
+	transactionID uint16
+	unitID        byte
+	function      protocol.ModbusFunction
+}
+
+// modbusTCPCodec Modbus TCP (MBAP) <-> V5 translation
+//
+// Every request carries the serial of the logger of its unit id, so a single connection can poll
+// units of several loggers (see ClientSolarman.SetRouter). Requests for unmapped units are
+// answered with an exception.
+type modbusTCPCodec struct {
+	conn    net.Conn
+	route   ModbusRoute
+	lock    sync.Mutex
+	seq     byte
+	pending map[byte]*mbapRequest
+}
+
+// NewModbusTCPClient - Initializes a Modbus TCP client. The requests are wrapped in V5 frames
+func NewModbusTCPClient(conn net.Conn, route ModbusRoute, serialRcv chan *CommSolarman, broadcast chan []byte) *ClientSolarman {
+	codec := &modbusTCPCodec{
+		conn:    conn,
+		route:   route,
+		pending: make(map[byte]*mbapRequest),
+	}
+	return NewCodecClient(conn, codec, serialRcv, broadcast)
+}
+
+func (c *modbusTCPCodec) ReadFrame() (*protocol.V5Frame, error) {
+	for {
+		req, err := protocol.ReadMBAP(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(req.PDU) == 0 {
+			continue
+		}
+		function := protocol.ModbusFunction(req.PDU[0])
+		serial, slave, ok := c.route.resolve(req.UnitID)
+		if !ok {
+			log.LogWarnf("Modbus TCP client [%s] no route for unit [%d]\n", c.conn.RemoteAddr().String(), req.UnitID)
+			c.writeException(req.TransactionID, req.UnitID, function, protocol.ExGatewayPathFailed)
+			continue
+		}
+		c.lock.Lock()
+		c.seq++
+		seq := c.seq
+		c.pending[seq] = &mbapRequest{transactionID: req.TransactionID, unitID: req.UnitID, function: function}
+		c.lock.Unlock()
+
+		return protocol.NewV5Builder(protocol.ControlRequest).
+			Serial(serial).
+			Sequence(uint16(seq)).
+			Modbus(protocol.PDUToRTU(slave, req.PDU)).
+			Frame(), nil
+	}
+}
+
+func (c *modbusTCPCodec) WriteFrame(data []byte) error {
+	frame, err := protocol.NewV5Frame(data)
+	if err != nil || frame.Control() != protocol.ControlResponse {
+		return nil // only responses can be translated
+	}
+	c.lock.Lock()
+	req, ok := c.pending[frame.RequestSequence()]
+	delete(c.pending, frame.RequestSequence())
+	c.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	rtu := frame.ModbusFrame()
+	pdu, err := protocol.RTUToPDU(rtu)
+	if err != nil {
+		log.LogWarnf("Modbus TCP client [%s] response without modbus frame\n", c.conn.RemoteAddr().String())
+		return c.writeException(req.transactionID, req.unitID, req.function, protocol.ExGatewayNoResponse)
+	}
+	if !protocol.CRCValid(rtu) {
+		log.LogWarnf("Modbus TCP client [%s] response with bad CRC\n", c.conn.RemoteAddr().String())
+	}
+	resp := &protocol.MBAPFrame{TransactionID: req.transactionID, UnitID: req.unitID, PDU: pdu}
+	_, err = c.conn.Write(resp.Bytes())
+	return err
+}
+
+func (c *modbusTCPCodec) writeException(tid uint16, unit byte, function protocol.ModbusFunction, code protocol.ModbusException) error {
+	resp := &protocol.MBAPFrame{TransactionID: tid, UnitID: unit, PDU: protocol.ExceptionPDU(function, code)}
+	_, err := c.conn.Write(resp.Bytes())
+	return err
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+type outstandingRequest struct {
+	client *ClientSolarman
+	// Sequence number used by the client
+	seq byte
+	// Logger serial used by the client
+	serial uint32
+	sent   time.Time
+}
+
+// requestTracker outstanding requests of a logger by V5 sequence number
+//
+// Only the low byte of the sequence number is echoed back by the data-logger, so every
+// request gets a proxy assigned value (unique per logger) in it. 0 is never assigned,
+// it is used by the serial probe.
+type requestTracker struct {
+	lock    sync.Mutex
+	pending map[byte]*outstandingRequest
+	last    byte
+}
+
+// add registers a request and returns the sequence number which should be sent to the logger
+func (t *requestTracker) add(seq byte, serial uint32, cl *ClientSolarman) byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[byte]*outstandingRequest)
+	}
+	t.expire()
+	proxySeq := t.nextFree()
+	t.pending[proxySeq] = &outstandingRequest{client: cl, seq: seq, serial: serial, sent: time.Now()}
+	return proxySeq
+}
+
+// take returns the request with the given (proxy assigned) sequence number if any
+func (t *requestTracker) take(seq byte) *outstandingRequest {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	req, ok := t.pending[seq]
+	if !ok {
+		return nil
+	}
+	delete(t.pending, seq)
+	return req
+}
+
+// nextFree next unused sequence number. When all are in use the oldest request is dropped
+func (t *requestTracker) nextFree() byte {
+	var oldest byte
+	for i := 0; i < 255; i++ {
+		t.last++
+		if t.last == 0 {
+			t.last = 1
+		}
+		req, used := t.pending[t.last]
+		if !used {
+			return t.last
+		}
+		if oldest == 0 || req.sent.Before(t.pending[oldest].sent) {
+			oldest = t.last
+		}
+	}
+	delete(t.pending, oldest)
+	return oldest
+}
+
+// expire drops outstanding requests without response
+func (t *requestTracker) expire() {
+	deadline := time.Now().Add(-timeouts.Load().Request)
+	for seq, req := range t.pending {
+		if req.sent.Before(deadline) {
+			delete(t.pending, seq)
+		}
+	}
+}
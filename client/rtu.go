@@ -0,0 +1,70 @@
+package client
+
+import (
+	"net"
+	"sync"
+
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// rtuCodec Modbus RTU (over TCP) <-> V5 translation. All requests go to a single logger
+type rtuCodec struct {
+	conn    net.Conn
+	reader  *protocol.RTUReader
+	serial  uint32
+	lock    sync.Mutex
+	seq     byte
+	pending map[byte]bool
+}
+
+// NewRTUClient - Initializes a Modbus RTU over TCP client bound to the logger with the given serial
+func NewRTUClient(conn net.Conn, serial uint32, serialRcv chan *CommSolarman, broadcast chan []byte) *ClientSolarman {
+	codec := &rtuCodec{
+		conn:    conn,
+		reader:  protocol.NewRTUReader(conn),
+		serial:  serial,
+		pending: make(map[byte]bool),
+	}
+	return NewCodecClient(conn, codec, serialRcv, broadcast)
+}
+
+func (c *rtuCodec) ReadFrame() (*protocol.V5Frame, error) {
+	rtu, err := c.reader.ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	c.seq++
+	seq := c.seq
+	c.pending[seq] = true
+	c.lock.Unlock()
+
+	return protocol.NewV5Builder(protocol.ControlRequest).
+		Serial(c.serial).
+		Sequence(uint16(seq)).
+		Modbus(rtu).
+		Frame(), nil
+}
+
+func (c *rtuCodec) WriteFrame(data []byte) error {
+	frame, err := protocol.NewV5Frame(data)
+	if err != nil || frame.Control() != protocol.ControlResponse {
+		return nil // only responses can be translated
+	}
+	c.lock.Lock()
+	ok := c.pending[frame.RequestSequence()]
+	delete(c.pending, frame.RequestSequence())
+	c.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	rtu := frame.ModbusFrame()
+	if !protocol.CRCValid(rtu) {
+		log.LogWarnf("RTU client [%s] response with bad CRC dropped\n", c.conn.RemoteAddr().String())
+		return nil
+	}
+	_, err = c.conn.Write(rtu)
+	return err
+}
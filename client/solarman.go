@@ -3,10 +3,11 @@ package client
 import (
 	"encoding/hex"
 	log "github.com/githubDante/go-solarman-proxy/logging"
-	"github.com/githubDante/go-solarman-proxy/protocol"
 	"net"
 	"sync/atomic"
 	"time"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
 )
 
 var clientId uint32 = 0
@@ -20,78 +21,131 @@ type CommSolarman struct {
 	Client *ClientSolarman
 }
 
+// UnroutedPolicy handling of the requests from clients which are not bound to a logger
+type UnroutedPolicy int
+
+const (
+	// UnroutedBroadcast requests are written to all loggers with unknown serial
+	UnroutedBroadcast UnroutedPolicy = iota
+	// UnroutedReject requests are answered with Modbus exception (gateway path unavailable)
+	UnroutedReject
+	// UnroutedDrop requests are dropped, the client waits for its logger
+	UnroutedDrop
+)
+
 // ClientSolarman - А client (e.g. from PySolarmanV5) connected to the proxy
 type ClientSolarman struct {
 	Conn   net.Conn
 	Serial uint32
-	Logger *ClientLogger
+	logger atomic.Pointer[ClientLogger]
 	// Serial number reporter
 	SReport   chan *CommSolarman
 	broadcast chan []byte
-	Running   bool
-	Id        uint32
+	running   atomic.Bool
+	// The client sent its first request (Serial is valid, 0 included)
+	identified atomic.Bool
+	Id         uint32
+	strict     bool
+	counters   frameCounters
+	codec      ClientCodec
+	unrouted   UnroutedPolicy
+	// Per request logger lookup by serial (optional, see SetRouter)
+	router func(serial uint32) *ClientLogger
 }
 
 func NewSolarmanClient(conn net.Conn, serialRcv chan *CommSolarman, broadcast chan []byte) *ClientSolarman {
+	return NewCodecClient(conn, newV5Codec(conn), serialRcv, broadcast)
+}
+
+// NewCodecClient - Initializes a client which is not speaking V5 (see ClientCodec)
+func NewCodecClient(conn net.Conn, codec ClientCodec, serialRcv chan *CommSolarman, broadcast chan []byte) *ClientSolarman {
 	return &ClientSolarman{
 		Conn:      conn,
 		SReport:   serialRcv,
 		broadcast: broadcast,
-		Running:   false,
 		Id:        nextId(),
+		codec:     codec,
 	}
 }
 
 func (s *ClientSolarman) Run() {
-	s.Running = true
+	s.running.Store(true)
 	defer func() {
-		s.Running = false
+		s.running.Store(false)
 	}()
+	if s.Serial != 0 {
+		s.identified.Store(true) // serial preset by the proxy (e.g. pty bridge)
+	}
 	for {
-		if s.Serial == 0 {
-			// The solarman client should send data in 1 minute, otherwise will be disconnected
-			s.Conn.SetReadDeadline(time.Now().Add(1 * time.Minute))
+		if !s.Identified() {
+			// The solarman client should send data in 1 minute (Timeouts.Identify), otherwise will be disconnected
+			s.Conn.SetReadDeadline(time.Now().Add(timeouts.Load().Identify))
 		} else {
 			s.Conn.SetReadDeadline(time.Time{})
 		}
-		buffer := make([]byte, 4096)
 		log.LogDebugf("Client <%p> waiting for data...\n", s)
-		pLen, err := s.Conn.Read(buffer)
-		if err != nil || pLen == 0 {
+		packet, err := s.codec.ReadFrame()
+		if err != nil {
 			log.LogErrorf("Client read error: %s\n", err.Error())
 			s.Conn.Close()
 			return
 		}
-		if s.Serial == 0 {
-			packet, err := protocol.NewV5Frame(buffer[:pLen])
-			if err == nil {
-				s.Serial = packet.LoggerSN()
-				log.LogWarnf("Client [%s] will use serial number <%d>\n", s.Conn.RemoteAddr().String(), s.Serial)
-				s.SReport <- &CommSolarman{
-					Serial: s.Serial,
-					Client: s,
-				}
-				time.Sleep(5 * time.Millisecond) // for logger association
-			} else {
-				log.LogErrorf("Bad packet from client <%p>... Forwarding refused.\n", s)
+		s.counters.received()
+		if s.strict {
+			if err := packet.Validate(); err != nil {
+				dropped := s.counters.dropped(dropReason(err))
+				log.LogWarnf("Client <%p> frame dropped [%s]. Dropped frames [%d]\n", s, err.Error(), dropped)
+				log.LogDebugf("Client <%p> dropped frame: %s\n", s, hex.EncodeToString(packet.Bytes()))
 				continue
 			}
 		}
-		if s.Logger != nil {
-			//s.Logger.Conn.Write(buffer[:pLen])
-			log.LogDebugf("Client <%p> sending data: %s\n", s, hex.EncodeToString(buffer[:pLen]))
-			s.Logger.Send(buffer[:pLen], s)
+		data := packet.Bytes()
+		if !s.Identified() {
+			s.Serial = packet.LoggerSN()
+			s.identified.Store(true)
+			log.LogWarnf("Client [%s] will use serial number <%d>\n", s.Conn.RemoteAddr().String(), s.Serial)
+			s.SReport <- &CommSolarman{
+				Serial: s.Serial,
+				Client: s,
+			}
+			time.Sleep(5 * time.Millisecond) // for logger association
+		}
+		if mb, err := packet.Modbus(); err == nil {
+			log.LogDebugf("Client <%p> request: %s\n", s, mb)
+		}
+		logger := s.Logger()
+		if s.router != nil {
+			logger = s.router(packet.LoggerSN())
+		}
+		if logger != nil {
+			log.LogDebugf("Client <%p> sending data: %s\n", s, hex.EncodeToString(data))
+			logger.Send(data, s)
 		} else {
-			log.LogDebugf("Client <%p> has no logger. Broadcasting data: %s\n",
-				s, hex.EncodeToString(buffer[:pLen]))
-			s.broadcast <- buffer[:pLen]
+			s.handleUnrouted(packet)
 		}
 	}
 }
 
+// Running reports whether the client read loop is active
+func (s *ClientSolarman) Running() bool {
+	return s.running.Load()
+}
+
+// Identified reports whether the serial used by the client is known
+func (s *ClientSolarman) Identified() bool {
+	return s.identified.Load()
+}
+
+// Pinned reports whether the logger serial of the client is set by the proxy configuration
+// (dedicated listener, Modbus TCP/RTU frontend) instead of the client
+func (s *ClientSolarman) Pinned() bool {
+	_, v5 := s.codec.(*v5Codec)
+	return !v5
+}
+
 // Stop closes the client connection
 func (s *ClientSolarman) Stop() {
-	if s.Running {
+	if s.Running() {
 		_, _ = s.Conn.Write([]byte{})
 	}
 	_ = s.Conn.Close()
@@ -99,15 +153,68 @@ func (s *ClientSolarman) Stop() {
 
 func (s *ClientSolarman) AddLogger(l *ClientLogger) {
 	log.LogWarnf("Adding <%p> as logger to <%p>\n", l, s)
-	s.Logger = l
+	s.logger.Store(l)
+}
+
+// Logger the data-logger the client is bound to (nil if pending)
+func (s *ClientSolarman) Logger() *ClientLogger {
+	return s.logger.Load()
+}
+
+// EnableStrict activates strict frame validation. Invalid frames from the client are dropped
+func (s *ClientSolarman) EnableStrict() {
+	log.LogDebugf("Client <%p> strict frame validation activated.\n", s)
+	s.strict = true
+}
+
+// SetRouter routes every request to the logger returned for its serial instead of the logger
+// the client is bound to (e.g. Modbus TCP clients polling units of several loggers).
+// A nil logger is handled by the UnroutedPolicy. Must be called before Run
+func (s *ClientSolarman) SetRouter(router func(serial uint32) *ClientLogger) {
+	s.router = router
+}
+
+// SetUnroutedPolicy sets the handling of the requests sent while the client is not bound to a logger
+func (s *ClientSolarman) SetUnroutedPolicy(p UnroutedPolicy) {
+	s.unrouted = p
+}
+
+// handleUnrouted handles a request of a client without logger according to its UnroutedPolicy
+func (s *ClientSolarman) handleUnrouted(packet *protocol.V5Frame) {
+	switch s.unrouted {
+	case UnroutedReject:
+		log.LogDebugf("Client <%p> has no logger. Request rejected.\n", s)
+		mb, err := packet.Modbus()
+		if err != nil {
+			return
+		}
+		rtu := protocol.PDUToRTU(mb.SlaveID, protocol.ExceptionPDU(mb.Function, protocol.ExGatewayPathFailed))
+		resp := protocol.NewV5Builder(protocol.ControlResponse).
+			Sequence(packet.Sequence()).
+			Serial(packet.LoggerSN()).
+			Modbus(rtu).
+			Marshal()
+		_ = s.Send(resp)
+	case UnroutedDrop:
+		log.LogDebugf("Client <%p> has no logger. Request dropped.\n", s)
+	default:
+		log.LogDebugf("Client <%p> has no logger. Broadcasting data: %s\n",
+			s, hex.EncodeToString(packet.Bytes()))
+		s.broadcast <- packet.Bytes()
+	}
+}
+
+// Stats frame counters of the client connection
+func (s *ClientSolarman) Stats() FrameStats {
+	return s.counters.snapshot()
 }
 
 // Send will send data to connected client
 //
-// Extra operations can be performed too. Currently only for logging/debug
+// The V5 frame is converted by the client codec
 func (s *ClientSolarman) Send(data []byte) error {
-	log.LogDebugf("Client <%p> sending data from <%p>\n", s, s.Logger)
-	_, err := s.Conn.Write(data)
+	log.LogDebugf("Client <%p> sending data from <%p>\n", s, s.Logger())
+	err := s.codec.WriteFrame(data)
 	if err != nil {
 		log.LogErrorf("Client send error <%s>:  %s\n", s.Conn.RemoteAddr().String(), err.Error())
 	}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// FrameStats per connection frame counters
+type FrameStats struct {
+	Received uint64
+	Dropped  uint64
+	// Dropped frames by reason
+	Reasons map[string]uint64
+}
+
+type frameCounters struct {
+	lock  sync.Mutex
+	stats FrameStats
+}
+
+func (fc *frameCounters) received() {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.stats.Received++
+}
+
+// dropped registers a rejected frame and returns the total number of dropped frames
+func (fc *frameCounters) dropped(reason string) uint64 {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if fc.stats.Reasons == nil {
+		fc.stats.Reasons = make(map[string]uint64)
+	}
+	fc.stats.Dropped++
+	fc.stats.Reasons[reason]++
+	return fc.stats.Dropped
+}
+
+func (fc *frameCounters) snapshot() FrameStats {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	stats := fc.stats
+	stats.Reasons = make(map[string]uint64, len(fc.stats.Reasons))
+	for r, n := range fc.stats.Reasons {
+		stats.Reasons[r] = n
+	}
+	return stats
+}
+
+// dropReason reduces a validation error to its class, used as counter key
+func dropReason(err error) string {
+	for _, class := range []error{protocol.ErrLength, protocol.ErrTrailer, protocol.ErrChecksum, protocol.ErrModbusCRC} {
+		if errors.Is(err, class) {
+			return class.Error()
+		}
+	}
+	return err.Error()
+}
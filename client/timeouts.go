@@ -0,0 +1,43 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts of the logger and client connections
+type Timeouts struct {
+	// Deadline for socket write operations
+	Write time.Duration
+	// Outstanding requests older than this are discarded
+	Request time.Duration
+	// A new client should send its first request within this time, otherwise it is disconnected
+	Identify time.Duration
+}
+
+// DefaultTimeouts timeouts used unless changed by SetTimeouts
+var DefaultTimeouts = Timeouts{
+	Write:    200 * time.Millisecond,
+	Request:  30 * time.Second,
+	Identify: 1 * time.Minute,
+}
+
+var timeouts atomic.Pointer[Timeouts]
+
+func init() {
+	SetTimeouts(DefaultTimeouts)
+}
+
+// SetTimeouts replaces the timeouts. The change applies to the connections immediately
+func SetTimeouts(t Timeouts) {
+	timeouts.Store(&t)
+}
+
+// CurrentTimeouts the timeouts in use
+func CurrentTimeouts() Timeouts {
+	return *timeouts.Load()
+}
+
+func writeTimeout() time.Duration {
+	return timeouts.Load().Write
+}
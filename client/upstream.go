@@ -0,0 +1,142 @@
+package client
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+const (
+	upstreamDialTimeout = 5 * time.Second
+	upstreamRetry       = 30 * time.Second // Delay before redialing an unreachable upstream
+	upstreamQueueLen    = 16
+)
+
+// UpstreamRoute cloud servers receiving the frames initiated by the data-loggers
+type UpstreamRoute struct {
+	// Upstream for loggers without own entry (disabled when empty)
+	Default string
+	// Data-logger serial to upstream address
+	Loggers map[uint32]string
+}
+
+// resolve returns the upstream address for the logger ("" - no upstream)
+func (r *UpstreamRoute) resolve(serial uint32) string {
+	if addr, ok := r.Loggers[serial]; ok {
+		return addr
+	}
+	return r.Default
+}
+
+// upstream connection of a single data-logger
+//
+// conn and retry are owned by the forwarding goroutine
+type upstream struct {
+	addr   string
+	frames chan *protocol.V5Frame
+	conn   net.Conn
+	retry  time.Time
+}
+
+// EnableUpstream tees the logger initiated frames (handshake, data, heartbeat...) to a cloud server
+//
+// The replies of the upstream are passed back to the logger. The proxy replies on its own
+// while the upstream is unreachable. Requests from the clients are not affected
+func (c *ClientLogger) EnableUpstream(route UpstreamRoute) {
+	c.upstreamRoute = &route
+}
+
+// teeUpstream queues a logger initiated frame for the upstream
+//
+// false is returned when the frame is not forwarded and must be answered by the proxy
+func (c *ClientLogger) teeUpstream(packet *protocol.V5Frame) bool {
+	if c.upstreamRoute == nil {
+		return false
+	}
+	if c.upstream == nil {
+		addr := c.upstreamRoute.resolve(c.Serial)
+		if addr == "" {
+			c.upstreamRoute = nil
+			return false
+		}
+		log.LogInfof("Logger [%d] frames will be forwarded to upstream <%s>\n", c.Serial, addr)
+		c.upstream = &upstream{addr: addr, frames: make(chan *protocol.V5Frame, upstreamQueueLen)}
+		go c.runUpstream(c.upstream)
+	}
+	select {
+	case c.upstream.frames <- packet:
+		return true
+	default:
+		log.LogWarnf("Logger <%p> upstream queue full\n", c)
+		return false
+	}
+}
+
+// stopUpstream stops the forwarding goroutine. Called by the read loop on exit
+func (c *ClientLogger) stopUpstream() {
+	if c.upstream != nil {
+		close(c.upstream.frames)
+	}
+}
+
+// runUpstream forwards the queued frames until the logger disconnects
+func (c *ClientLogger) runUpstream(u *upstream) {
+	defer func() {
+		if u.conn != nil {
+			_ = u.conn.Close()
+		}
+	}()
+	for packet := range u.frames {
+		if u.conn == nil && time.Now().After(u.retry) {
+			conn, err := net.DialTimeout("tcp", u.addr, upstreamDialTimeout)
+			if err != nil {
+				log.LogErrorf("Logger <%p> upstream <%s> dial error: %s\n", c, u.addr, err.Error())
+				u.retry = time.Now().Add(upstreamRetry)
+			} else {
+				log.LogInfof("Logger <%p> connected to upstream <%s>\n", c, u.addr)
+				u.conn = conn
+				go c.upstreamReplies(conn)
+			}
+		}
+		if u.conn == nil {
+			c.reply(packet)
+			continue
+		}
+		log.LogDebugf("Logger <%p> forwarding %s frame upstream: %s\n", c, packet.Control(), hex.EncodeToString(packet.Bytes()))
+		u.conn.SetWriteDeadline(time.Now().Add(writeTimeout()))
+		if _, err := u.conn.Write(packet.Bytes()); err != nil {
+			log.LogErrorf("Logger <%p> upstream <%s> write error: %s\n", c, u.addr, err.Error())
+			_ = u.conn.Close()
+			u.conn = nil
+			c.reply(packet)
+		}
+	}
+}
+
+// upstreamReplies passes the replies of the upstream to the logger
+//
+// Anything else sent by the upstream (e.g. requests from the vendor app) is dropped
+func (c *ClientLogger) upstreamReplies(conn net.Conn) {
+	defer conn.Close()
+	reader := protocol.NewFrameReader(conn)
+	for {
+		packet, err := reader.ReadFrame()
+		if err != nil {
+			log.LogWarnf("Logger <%p> upstream read error: %s\n", c, err.Error())
+			return
+		}
+		if !packet.Control().IsResponse() {
+			log.LogDebugf("Logger <%p> upstream %s frame dropped\n", c, packet.Control())
+			continue
+		}
+		log.LogDebugf("Logger <%p> upstream %s: %s\n", c, packet.Control(), hex.EncodeToString(packet.Bytes()))
+		c.Conn.SetWriteDeadline(time.Now().Add(writeTimeout()))
+		if _, err := c.Conn.Write(packet.Bytes()); err != nil {
+			log.LogErrorf("Cannot pass upstream reply to logger <%p>: %s\n", c, err.Error())
+			return
+		}
+	}
+}
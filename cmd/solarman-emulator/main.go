@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/githubDante/go-solarman-proxy/emulator"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "%s [flags] <config file> <proxy address:loggers port>\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Flags:\n")
+		flag.PrintDefaults()
+	}
+	debug := flag.Bool("debug", false, "enable debug logging")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 2 {
+		log.LogErrorf("[%s] requires config file and proxy address\n", os.Args[0])
+		os.Exit(1)
+	}
+	if *debug {
+		log.EnableDebug()
+	}
+	cfg, err := emulator.LoadConfig(args[0])
+	if err != nil {
+		log.LogErrorf("Config error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	emulator.New(cfg).Run(args[1])
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	"github.com/githubDante/go-solarman-proxy/server"
+)
+
+// Config proxy settings. Loaded from a YAML file (-config), the command line flags override
+// the file values. List values use the same syntax as the respective flags
+type Config struct {
+	// Data-loggers listener
+	Address string `yaml:"address"`
+	Port    uint16 `yaml:"port"`
+	Network string `yaml:"network,omitempty"`
+
+	// Clients listeners [network://]address[=serial]
+	Clients []string   `yaml:"clients"`
+	UDP     string     `yaml:"udp,omitempty"`
+	Unix    UnixConfig `yaml:"unix,omitempty"`
+
+	Broadcast bool `yaml:"broadcast"`
+	Buffered  bool `yaml:"buffered"`
+	Strict    bool `yaml:"strict"`
+	// info, debug or silent
+	Log      string         `yaml:"log"`
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+
+	DataAPI string `yaml:"data_api,omitempty"`
+	// PUT/DELETE /aliases on the data API (unauthenticated)
+	DataAPIAliases bool                `yaml:"data_api_aliases,omitempty"`
+	Probe          ProbeConfig         `yaml:"probe"`
+	Pty            PtyConfig           `yaml:"pty"`
+	Modbus         ModbusConfig        `yaml:"modbus,omitempty"`
+	UnknownSerial  UnknownSerialConfig `yaml:"unknown_serial"`
+	Upstream       string              `yaml:"upstream,omitempty"`
+	// Data-logger serial -> upstream address
+	Upstreams map[uint32]string `yaml:"upstreams,omitempty"`
+	// Virtual serial -> data-logger serial
+	Aliases map[uint32]uint32 `yaml:"aliases,omitempty"`
+	// Data-loggers in TCP-server mode address[=serial]
+	Dial []string `yaml:"dial,omitempty"`
+
+	// Per-logger settings. Merged into the lists above (see mergeLoggers)
+	Loggers []LoggerConfig `yaml:"loggers,omitempty"`
+}
+
+// UnixConfig unix domain socket clients listener
+type UnixConfig struct {
+	Path string `yaml:"path,omitempty"`
+	// Octal file mode (e.g. "0660")
+	Mode string `yaml:"mode,omitempty"`
+	// user[:group]
+	Owner string `yaml:"owner,omitempty"`
+}
+
+// TimeoutsConfig connection timeouts
+type TimeoutsConfig struct {
+	Janitor  time.Duration `yaml:"janitor"`
+	Write    time.Duration `yaml:"write"`
+	Request  time.Duration `yaml:"request"`
+	Identify time.Duration `yaml:"identify"`
+}
+
+// ProbeConfig serial probe request
+type ProbeConfig struct {
+	Slave    uint8  `yaml:"slave"`
+	Register uint16 `yaml:"register"`
+}
+
+// PtyConfig pseudo-terminal bridges
+type PtyConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+}
+
+// ModbusConfig Modbus TCP and RTU over TCP frontends
+type ModbusConfig struct {
+	// address[=serial]
+	TCP []string `yaml:"tcp,omitempty"`
+	// unit id -> logger serial
+	Units map[byte]uint32 `yaml:"units,omitempty"`
+	// address=serial
+	RTU []string `yaml:"rtu,omitempty"`
+}
+
+// UnknownSerialConfig handling of clients with unknown serial
+type UnknownSerialConfig struct {
+	// broadcast, default, reject or pending
+	Mode          string `yaml:"mode"`
+	DefaultLogger uint32 `yaml:"default_logger,omitempty"`
+}
+
+// LoggerConfig settings of a single data-logger
+type LoggerConfig struct {
+	Serial uint32 `yaml:"serial"`
+	// Address of the logger in TCP-server mode
+	Dial     string `yaml:"dial,omitempty"`
+	Upstream string `yaml:"upstream,omitempty"`
+	// Virtual serials of the logger
+	Aliases []uint32 `yaml:"aliases,omitempty"`
+	// Dedicated clients listeners [network://]address
+	Clients []string `yaml:"clients,omitempty"`
+}
+
+// defaultConfig the settings used without config file and flags
+func defaultConfig() *Config {
+	return &Config{
+		Clients: []string{"tcp4://0.0.0.0:8899"},
+		Log:     "info",
+		Timeouts: TimeoutsConfig{
+			Janitor:  30 * time.Second,
+			Write:    client.DefaultTimeouts.Write,
+			Request:  client.DefaultTimeouts.Request,
+			Identify: client.DefaultTimeouts.Identify,
+		},
+		Probe:         ProbeConfig{Slave: 1, Register: 1},
+		Pty:           PtyConfig{Dir: server.DefaultPtyDir},
+		UnknownSerial: UnknownSerialConfig{Mode: server.UnknownBroadcast.String()},
+	}
+}
+
+// loadConfig reads a config file on top of the defaults. Unknown keys are rejected
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// mergeLoggers moves the per-logger settings into the lists. Called after the flags are applied,
+// so the flags replace only the top-level lists. The top-level map entries (aliases, upstreams)
+// take precedence over the per-logger ones
+func (c *Config) mergeLoggers() error {
+	for i, l := range c.Loggers {
+		if l.Serial == 0 {
+			return fmt.Errorf("loggers[%d]: serial number is required", i)
+		}
+		if l.Dial != "" {
+			c.Dial = append(c.Dial, fmt.Sprintf("%s=%d", l.Dial, l.Serial))
+		}
+		if _, ok := c.Upstreams[l.Serial]; l.Upstream != "" && !ok {
+			c.Upstreams = mergeMap(c.Upstreams, map[uint32]string{l.Serial: l.Upstream})
+		}
+		for _, virtual := range l.Aliases {
+			if _, ok := c.Aliases[virtual]; !ok {
+				c.Aliases = mergeMap(c.Aliases, map[uint32]uint32{virtual: l.Serial})
+			}
+		}
+		for _, addr := range l.Clients {
+			c.Clients = append(c.Clients, fmt.Sprintf("%s=%d", addr, l.Serial))
+		}
+	}
+	c.Loggers = nil
+	return nil
+}
+
+// validate checks the settings. The errors name the offending key
+func (c *Config) validate() error {
+	if c.Port == 0 {
+		return errors.New("port: the data-loggers port is required")
+	}
+	if c.Network != "" {
+		if err := checkNetwork(c.Network); err != nil {
+			return fmt.Errorf("network: %w", err)
+		}
+	}
+	if len(c.Clients) == 0 && c.UDP == "" && c.Unix.Path == "" {
+		return errors.New("clients: at least one clients listener is required")
+	}
+	if _, err := parseClientListeners(c.Clients); err != nil {
+		return fmt.Errorf("clients: %w", err)
+	}
+	if _, err := parseUnixListener(c.Unix.Path, c.Unix.Mode, c.Unix.Owner); err != nil {
+		return fmt.Errorf("unix: %w", err)
+	}
+	switch c.Log {
+	case "info", "debug", "silent":
+	default:
+		return fmt.Errorf("log: bad level <%s>, expected info, debug or silent", c.Log)
+	}
+	t := c.Timeouts
+	if t.Janitor <= 0 || t.Write <= 0 || t.Request <= 0 || t.Identify <= 0 {
+		return errors.New("timeouts: janitor, write, request and identify must be positive")
+	}
+	if c.DataAPIAliases && c.DataAPI == "" {
+		return errors.New("data_api_aliases: requires data_api")
+	}
+	if c.Pty.Enabled && c.Pty.Dir == "" {
+		return errors.New("pty: dir is required")
+	}
+	if _, err := parseModbusListeners(c.Modbus.TCP, c.Modbus.Units); err != nil {
+		return fmt.Errorf("modbus.tcp: %w", err)
+	}
+	if _, err := parseRTUListeners(c.Modbus.RTU); err != nil {
+		return fmt.Errorf("modbus.rtu: %w", err)
+	}
+	mode, err := server.ParseUnknownSerialMode(c.UnknownSerial.Mode)
+	if err != nil {
+		return fmt.Errorf("unknown_serial.mode: %w", err)
+	}
+	if mode == server.UnknownDefault && c.UnknownSerial.DefaultLogger == 0 {
+		return errors.New("unknown_serial.default_logger: required by mode default")
+	}
+	for virtual, physical := range c.Aliases {
+		if virtual == 0 || physical == 0 {
+			return fmt.Errorf("aliases: bad alias <%d=%d>", virtual, physical)
+		}
+	}
+	if _, err = parseLoggerDials(c.Dial); err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	return nil
+}
+
+// newProxy creates the proxy server from validated settings
+func newProxy(c *Config) *server.V5ProxyServer {
+	client.SerialProbe.SlaveID = c.Probe.Slave
+	client.SerialProbe.Address = c.Probe.Register
+	client.SetTimeouts(client.Timeouts{Write: c.Timeouts.Write, Request: c.Timeouts.Request, Identify: c.Timeouts.Identify})
+
+	proxy := server.NewProxy(c.Address, int(c.Port))
+	proxy.LoggersNetwork = c.Network
+	proxy.JanitorInterval = c.Timeouts.Janitor
+	mode, _ := server.ParseUnknownSerialMode(c.UnknownSerial.Mode)
+	proxy.RouteUnknownSerials(mode, c.UnknownSerial.DefaultLogger)
+	for virtual, physical := range c.Aliases {
+		proxy.SetAlias(virtual, physical)
+	}
+	clients, _ := parseClientListeners(c.Clients)
+	for _, l := range clients {
+		proxy.AddClientListener(l)
+	}
+	if c.Strict {
+		proxy.EnableStrict()
+	}
+	if c.Unix.Path != "" {
+		unixL, _ := parseUnixListener(c.Unix.Path, c.Unix.Mode, c.Unix.Owner)
+		proxy.EnableUnix(unixL)
+	}
+	if c.UDP != "" {
+		proxy.EnableUDP(c.UDP)
+	}
+	if c.DataAPI != "" {
+		proxy.EnableDataAPI(c.DataAPI)
+	}
+	if c.DataAPIAliases {
+		proxy.EnableAliasEditing()
+	}
+	if c.Pty.Enabled {
+		proxy.EnablePty(c.Pty.Dir)
+	}
+	modbusListeners, _ := parseModbusListeners(c.Modbus.TCP, c.Modbus.Units)
+	rtuListeners, _ := parseRTUListeners(c.Modbus.RTU)
+	for _, l := range append(modbusListeners, rtuListeners...) {
+		proxy.AddModbusTCP(l)
+	}
+	if c.Upstream != "" || len(c.Upstreams) > 0 {
+		proxy.EnableUpstream(client.UpstreamRoute{Default: c.Upstream, Loggers: c.Upstreams})
+	}
+	dials, _ := parseLoggerDials(c.Dial)
+	for _, d := range dials {
+		proxy.AddLoggerDial(d)
+	}
+	return proxy
+}
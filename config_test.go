@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resolve writes the YAML to a temp file (if not empty) and resolves it with the arguments
+func resolve(t *testing.T, yamlText string, args ...string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var flags cmdFlags
+	flags.register(fs)
+	if yamlText != "" {
+		path := filepath.Join(t.TempDir(), "proxy.yaml")
+		if err := os.WriteFile(path, []byte(yamlText), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", path}, args...)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return resolveConfig(&flags, fs.Args())
+}
+
+func TestResolveConfig(t *testing.T) {
+	const base = "port: 10000\n"
+	for _, tc := range []struct {
+		name  string
+		yaml  string
+		args  []string
+		check func(c *Config) any
+		want  any
+	}{
+		{"defaults", "", []string{"0.0.0.0", "10000"},
+			func(c *Config) any { return []any{c.Clients, c.Log, c.Timeouts.Janitor, c.UnknownSerial.Mode} },
+			[]any{[]string{"tcp4://0.0.0.0:8899"}, "info", 30 * time.Second, "broadcast"}},
+		{"file values", base + "address: 127.0.0.1\nbuffered: true\ntimeouts:\n  request: 3s\n", nil,
+			func(c *Config) any { return []any{c.Address, c.Port, c.Buffered, c.Timeouts.Request} },
+			[]any{"127.0.0.1", uint16(10000), true, 3 * time.Second}},
+		{"positional address and port", base + "address: 127.0.0.1\n", []string{"::1", "10001"},
+			func(c *Config) any { return []any{c.Address, c.Port} }, []any{"::1", uint16(10001)}},
+		{"debug flag", base, []string{"-debug"},
+			func(c *Config) any { return c.Log }, "debug"},
+		{"debug=false over file", base + "log: debug\n", []string{"-debug=false"},
+			func(c *Config) any { return c.Log }, "info"},
+		{"silent=false over file", base + "log: silent\n", []string{"-silent=false"},
+			func(c *Config) any { return c.Log }, "info"},
+		{"debug and silent", base, []string{"-silent", "-debug"},
+			func(c *Config) any { return c.Log }, "silent"},
+		{"debug=false keeps silent", base + "log: silent\n", []string{"-debug=false"},
+			func(c *Config) any { return c.Log }, "silent"},
+		{"bool flag over file", base + "buffered: true\nstrict: true\n", []string{"-buffered=false"},
+			func(c *Config) any { return []bool{c.Buffered, c.Strict} }, []bool{false, true}},
+		{"string flag over file", base + "unknown_serial:\n  mode: reject\n", []string{"-unknown-serial", "pending"},
+			func(c *Config) any { return c.UnknownSerial.Mode }, "pending"},
+		{"unset flag keeps file", base + "unknown_serial:\n  mode: reject\n", nil,
+			func(c *Config) any { return c.UnknownSerial.Mode }, "reject"},
+		{"list flag replaces list", base + "clients: [\":9000\", \":9001\"]\n", []string{"-clients", ":9002"},
+			func(c *Config) any { return c.Clients }, []string{":9002"}},
+		{"map flag replaces entry", base + "aliases: {100: 5, 101: 6}\n", []string{"-alias", "100=7"},
+			func(c *Config) any { return c.Aliases }, map[uint32]uint32{100: 7, 101: 6}},
+		{"logger clients appended", base + "clients: [\":9000\"]\nloggers:\n  - serial: 5\n    clients: [\":9001\"]\n",
+			[]string{"-clients", ":9002"},
+			func(c *Config) any { return []any{c.Clients, c.Loggers} },
+			[]any{[]string{":9002", ":9001=5"}, []LoggerConfig(nil)}},
+		{"logger dial appended", base + "dial: [\"10.0.0.1:8899=6\"]\nloggers:\n  - serial: 5\n    dial: 10.0.0.2:8899\n", nil,
+			func(c *Config) any { return c.Dial }, []string{"10.0.0.1:8899=6", "10.0.0.2:8899=5"}},
+		{"top-level alias wins", base + "aliases: {100: 5}\nloggers:\n  - serial: 6\n    aliases: [100, 102]\n", nil,
+			func(c *Config) any { return c.Aliases }, map[uint32]uint32{100: 5, 102: 6}},
+		{"alias flag wins", base + "loggers:\n  - serial: 6\n    aliases: [100]\n", []string{"-alias", "100=7"},
+			func(c *Config) any { return c.Aliases }, map[uint32]uint32{100: 7}},
+		{"top-level upstream wins", base + "upstreams: {5: a:10000}\nloggers:\n  - serial: 5\n    upstream: b:10000\n  - serial: 6\n    upstream: c:10000\n", nil,
+			func(c *Config) any { return c.Upstreams }, map[uint32]string{5: "a:10000", 6: "c:10000"}},
+		{"upstream flag wins", base + "loggers:\n  - serial: 5\n    upstream: b:10000\n", []string{"-upstream-logger", "5=d:10000"},
+			func(c *Config) any { return c.Upstreams }, map[uint32]string{5: "d:10000"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := resolve(t, tc.yaml, tc.args...)
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if got := tc.check(cfg); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveConfigErrors(t *testing.T) {
+	const base = "port: 10000\n"
+	for _, tc := range []struct {
+		name string
+		yaml string
+		args []string
+		want string
+	}{
+		{"missing file", "", []string{"-config", "/nonexistent/proxy.yaml"}, "config error:"},
+		{"unknown key", base + "brodcast: true\n", nil, "field brodcast not found"},
+		{"bad duration", base + "timeouts:\n  write: soon\n", nil, "time.Duration"},
+		{"port missing", "log: info\n", nil, "port:"},
+		{"bad positional port", base, []string{"0.0.0.0", "70000"}, "port error"},
+		{"bad network", base + "network: udp\n", nil, "network:"},
+		{"no clients listener", base + "clients: []\n", nil, "clients: at least one"},
+		{"bad clients listener", base + "clients: [\"udp://:9000\"]\n", nil, "clients:"},
+		{"bad log level", base + "log: verbose\n", nil, "log: bad level <verbose>"},
+		{"zero timeout", base + "timeouts:\n  janitor: 0s\n", nil, "timeouts:"},
+		{"alias editing without data api", base + "data_api_aliases: true\n", nil, "data_api_aliases: requires data_api"},
+		{"pty without dir", base + "pty:\n  enabled: true\n  dir: \"\"\n", nil, "pty: dir is required"},
+		{"bad modbus rtu", base + "modbus:\n  rtu: [\":9502\"]\n", nil, "modbus.rtu:"},
+		{"bad unknown serial mode", base + "unknown_serial:\n  mode: drop\n", nil, "unknown_serial.mode:"},
+		{"default without logger", base + "unknown_serial:\n  mode: default\n", nil, "unknown_serial.default_logger:"},
+		{"zero alias", base + "aliases: {100: 0}\n", nil, "aliases: bad alias <100=0>"},
+		{"bad dial serial", base + "dial: [\"10.0.0.1:8899=x\"]\n", nil, "dial: bad serial number <x>"},
+		{"logger without serial", base + "loggers:\n  - dial: 10.0.0.1:8899\n", nil, "loggers[0]: serial number is required"},
+		{"bad alias flag", base, []string{"-alias", "100=0"}, "bad serial number"},
+		{"probe slave out of range", base, []string{"-probe-slave", "300"}, "serial probe slave out of range"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := resolve(t, tc.yaml, tc.args...)
+			if err == nil {
+				t.Fatalf("no error, want %q", tc.want)
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("error %q, want %q", err, tc.want)
+			}
+		})
+	}
+}
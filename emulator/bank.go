@@ -0,0 +1,140 @@
+package emulator
+
+import (
+	"sync"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// RegisterBank in-memory Modbus data model of the emulated inverter
+type RegisterBank struct {
+	lock     sync.Mutex
+	holding  map[uint16]uint16
+	input    map[uint16]uint16
+	coils    map[uint16]bool
+	discrete map[uint16]bool
+	// Unmapped addresses read as 0 instead of IllegalDataAddress
+	unmappedZero bool
+}
+
+// NewRegisterBank creates a register bank initialized from the configuration
+func NewRegisterBank(cfg *Config) *RegisterBank {
+	b := &RegisterBank{
+		holding:      make(map[uint16]uint16),
+		input:        make(map[uint16]uint16),
+		coils:        make(map[uint16]bool),
+		discrete:     make(map[uint16]bool),
+		unmappedZero: cfg.UnmappedZero,
+	}
+	for a, v := range cfg.Holding {
+		b.holding[a] = v
+	}
+	for a, v := range cfg.Input {
+		b.input[a] = v
+	}
+	for a, v := range cfg.Coils {
+		b.coils[a] = v
+	}
+	for a, v := range cfg.Discrete {
+		b.discrete[a] = v
+	}
+	return b
+}
+
+// Holding value of a holding register
+func (b *RegisterBank) Holding(address uint16) (uint16, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	v, ok := b.holding[address]
+	return v, ok
+}
+
+// SetHolding sets a holding register
+func (b *RegisterBank) SetHolding(address, value uint16) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.holding[address] = value
+}
+
+// SetInput sets an input register
+func (b *RegisterBank) SetInput(address, value uint16) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.input[address] = value
+}
+
+// Execute applies a Modbus request to the bank and returns the response PDU
+func (b *RegisterBank) Execute(req *protocol.ModbusFrame) []byte {
+	if req.IsException() {
+		// exception responses sent as requests carry no address or data
+		return protocol.ExceptionPDU(req.Function, protocol.ExIllegalFunction)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch req.Function {
+	case protocol.FuncReadHoldingRegisters:
+		return b.readRegisters(req, b.holding)
+	case protocol.FuncReadInputRegisters:
+		return b.readRegisters(req, b.input)
+	case protocol.FuncReadCoils:
+		return b.readBits(req, b.coils)
+	case protocol.FuncReadDiscreteInputs:
+		return b.readBits(req, b.discrete)
+	case protocol.FuncWriteSingleRegister, protocol.FuncWriteMultipleRegisters:
+		for i, v := range req.Registers() {
+			b.holding[req.Address+uint16(i)] = v
+		}
+		return writeEcho(req)
+	case protocol.FuncWriteSingleCoil:
+		b.coils[req.Address] = req.Data[0] == 0xff
+		return writeEcho(req)
+	case protocol.FuncWriteMultipleCoils:
+		for i := 0; i < int(req.Quantity) && i/8 < len(req.Data); i++ {
+			b.coils[req.Address+uint16(i)] = req.Data[i/8]&(1<<(i%8)) != 0
+		}
+		return writeEcho(req)
+	default:
+		return protocol.ExceptionPDU(req.Function, protocol.ExIllegalFunction)
+	}
+}
+
+func (b *RegisterBank) readRegisters(req *protocol.ModbusFrame, regs map[uint16]uint16) []byte {
+	if req.Quantity == 0 || req.Quantity > 125 {
+		return protocol.ExceptionPDU(req.Function, protocol.ExIllegalValue)
+	}
+	pdu := []byte{byte(req.Function), byte(req.Quantity * 2)}
+	for i := uint16(0); i < req.Quantity; i++ {
+		v, ok := regs[req.Address+i]
+		if !ok && !b.unmappedZero {
+			return protocol.ExceptionPDU(req.Function, protocol.ExIllegalAddress)
+		}
+		pdu = append(pdu, byte(v>>8), byte(v))
+	}
+	return pdu
+}
+
+func (b *RegisterBank) readBits(req *protocol.ModbusFrame, bits map[uint16]bool) []byte {
+	if req.Quantity == 0 || req.Quantity > 2000 {
+		return protocol.ExceptionPDU(req.Function, protocol.ExIllegalValue)
+	}
+	data := make([]byte, (req.Quantity+7)/8)
+	for i := uint16(0); i < req.Quantity; i++ {
+		v, ok := bits[req.Address+i]
+		if !ok && !b.unmappedZero {
+			return protocol.ExceptionPDU(req.Function, protocol.ExIllegalAddress)
+		}
+		if v {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+	return append([]byte{byte(req.Function), byte(len(data))}, data...)
+}
+
+// writeEcho response PDU of a write request (address + value/quantity)
+func writeEcho(req *protocol.ModbusFrame) []byte {
+	rtu := req.Bytes()
+	if len(rtu) < 6 {
+		return protocol.ExceptionPDU(req.Function, protocol.ExIllegalValue)
+	}
+	return append([]byte{}, rtu[1:6]...)
+}
@@ -0,0 +1,98 @@
+package emulator
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config emulated data-logger settings. Loaded from YAML or JSON
+type Config struct {
+	Serial  uint32 `yaml:"serial"`
+	SlaveID byte   `yaml:"slave_id"`
+
+	// Response delay and random extra delay (up to Jitter)
+	Latency time.Duration `yaml:"latency"`
+	Jitter  time.Duration `yaml:"jitter"`
+	// Probability (0..1) of answering with ServerDeviceFailure exception
+	ErrorRate float64 `yaml:"error_rate"`
+	// Probability (0..1) of not answering at all
+	DropRate float64 `yaml:"drop_rate"`
+	// Probability (0..1) of a response with bad V5 checksum
+	CorruptRate float64 `yaml:"corrupt_rate"`
+	// Heartbeat frame interval (disabled when 0)
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	// Delay between reconnect attempts
+	Reconnect time.Duration `yaml:"reconnect"`
+
+	UnmappedZero bool              `yaml:"unmapped_zero"`
+	Holding      map[uint16]uint16 `yaml:"holding"`
+	Input        map[uint16]uint16 `yaml:"input"`
+	Coils        map[uint16]bool   `yaml:"coils"`
+	Discrete     map[uint16]bool   `yaml:"discrete"`
+}
+
+// LoadConfig reads an emulator configuration file (YAML or JSON)
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	cfg := &Config{SlaveID: 1, Reconnect: 5 * time.Second}
+	if len(doc.Content) == 0 {
+		return cfg, cfg.Validate()
+	}
+	numericKeys(doc.Content[0])
+	if err = doc.Content[0].Decode(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// numericKeys retags the quoted register addresses of the register maps as integers.
+// JSON object keys are always strings
+func numericKeys(root *yaml.Node) {
+	if root.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "holding", "input", "coils", "discrete":
+		default:
+			continue
+		}
+		bank := root.Content[i+1]
+		if bank.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j < len(bank.Content); j += 2 {
+			key := bank.Content[j]
+			if _, err := strconv.ParseUint(key.Value, 0, 16); err == nil && key.Tag == "!!str" {
+				key.Tag = "!!int"
+			}
+		}
+	}
+}
+
+// Validate checks the configuration values
+func (c *Config) Validate() error {
+	if c.Serial == 0 {
+		return errors.New("serial number is required")
+	}
+	for _, rate := range []float64{c.ErrorRate, c.DropRate, c.CorruptRate} {
+		if rate < 0 || rate > 1 {
+			return errors.New("error_rate, drop_rate and corrupt_rate must be between 0 and 1")
+		}
+	}
+	if c.Latency < 0 || c.Jitter < 0 || c.Heartbeat < 0 {
+		return errors.New("latency, jitter and heartbeat cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// Emulator SolarmanV5 data-logger in TCP-client mode backed by a RegisterBank
+type Emulator struct {
+	cfg  *Config
+	bank *RegisterBank
+	// data-logger part of the sequence number
+	seq   byte
+	wLock sync.Mutex
+}
+
+// New creates an emulator for the given configuration
+func New(cfg *Config) *Emulator {
+	return &Emulator{
+		cfg:  cfg,
+		bank: NewRegisterBank(cfg),
+	}
+}
+
+// Bank the register bank of the emulator
+func (e *Emulator) Bank() *RegisterBank {
+	return e.bank
+}
+
+// Run connects to the proxy loggers port and serves it forever (reconnecting on errors)
+func (e *Emulator) Run(addr string) {
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			log.LogErrorf("[Emulator] connection to <%s> failed: %s\n", addr, err.Error())
+		} else {
+			log.LogInfof("[Emulator] logger [%d] connected to <%s>\n", e.cfg.Serial, addr)
+			err = e.Serve(conn)
+			log.LogWarnf("[Emulator] connection closed: %s\n", err.Error())
+		}
+		time.Sleep(e.cfg.Reconnect)
+	}
+}
+
+// Serve answers the requests received over conn until the connection fails
+func (e *Emulator) Serve(conn net.Conn) error {
+	defer conn.Close()
+	if e.cfg.Heartbeat > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go e.heartbeat(conn, stop)
+	}
+	reader := protocol.NewFrameReader(conn)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			return err
+		}
+		if frame.Control() != protocol.ControlRequest {
+			log.LogDebugf("[Emulator] ignoring %s frame\n", frame.Control())
+			continue
+		}
+		go e.answer(conn, frame)
+	}
+}
+
+// answer executes a request and writes the response after the configured latency
+func (e *Emulator) answer(conn net.Conn, frame *protocol.V5Frame) {
+	req, err := frame.Modbus()
+	if err != nil || !req.CRCOK() {
+		log.LogWarnf("[Emulator] bad modbus request: %v\n", err)
+		return
+	}
+	if req.SlaveID != e.cfg.SlaveID {
+		log.LogDebugf("[Emulator] request for slave [%d] ignored\n", req.SlaveID)
+		return
+	}
+	delay := e.cfg.Latency
+	if e.cfg.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(e.cfg.Jitter)))
+	}
+	time.Sleep(delay)
+
+	if rand.Float64() < e.cfg.DropRate {
+		log.LogInfof("[Emulator] dropping %s\n", req)
+		return
+	}
+	var pdu []byte
+	if rand.Float64() < e.cfg.ErrorRate {
+		log.LogInfof("[Emulator] injecting exception for %s\n", req)
+		pdu = protocol.ExceptionPDU(req.Function, protocol.ExDeviceFailure)
+	} else {
+		pdu = e.bank.Execute(req)
+	}
+	log.LogDebugf("[Emulator] %s answered\n", req)
+
+	resp := e.frame(protocol.ControlResponse, frame.RequestSequence()).
+		Modbus(protocol.PDUToRTU(req.SlaveID, pdu)).
+		Marshal()
+	if rand.Float64() < e.cfg.CorruptRate {
+		log.LogInfof("[Emulator] corrupting response checksum\n")
+		resp[len(resp)-2]++
+	}
+	e.write(conn, resp)
+}
+
+// heartbeat sends heartbeat frames, like real data-loggers do
+func (e *Emulator) heartbeat(conn net.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(e.cfg.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			e.write(conn, e.frame(protocol.ControlHeartbeat, 0).Payload([]byte{0x00}).Marshal())
+		}
+	}
+}
+
+// frame builder for a data-logger frame. The high byte of the sequence number is the logger counter
+func (e *Emulator) frame(control protocol.ControlCode, reqSeq byte) *protocol.V5Builder {
+	e.wLock.Lock()
+	e.seq++
+	seq := binary.LittleEndian.Uint16([]byte{reqSeq, e.seq})
+	e.wLock.Unlock()
+	return protocol.NewV5Builder(control).Serial(e.cfg.Serial).Sequence(seq)
+}
+
+func (e *Emulator) write(conn net.Conn, data []byte) {
+	e.wLock.Lock()
+	defer e.wLock.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write(data); err != nil {
+		log.LogErrorf("[Emulator] write error: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,130 @@
+package emulator
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+func init() {
+	log.EnableSilent()
+}
+
+func request(t *testing.T, rtu ...byte) *protocol.ModbusFrame {
+	t.Helper()
+	req, err := protocol.ParseModbusRequest(protocol.AppendCRC(rtu))
+	if err != nil {
+		t.Fatalf("request [% x]: %v", rtu, err)
+	}
+	return req
+}
+
+func TestRegisterBankExecute(t *testing.T) {
+	bank := NewRegisterBank(&Config{
+		Holding: map[uint16]uint16{10: 1, 11: 2, 12: 3},
+		Input:   map[uint16]uint16{0: 7},
+		Coils:   map[uint16]bool{0: true, 1: false, 2: true},
+	})
+	for _, tc := range []struct {
+		name string
+		rtu  []byte
+		want []byte
+	}{
+		{"read holding", []byte{0x01, 0x03, 0x00, 0x0a, 0x00, 0x02}, []byte{0x03, 0x04, 0x00, 0x01, 0x00, 0x02}},
+		{"read unmapped", []byte{0x01, 0x03, 0x00, 0x14, 0x00, 0x01}, []byte{0x83, 0x02}},
+		{"read quantity 0", []byte{0x01, 0x03, 0x00, 0x0a, 0x00, 0x00}, []byte{0x83, 0x03}},
+		{"read input", []byte{0x01, 0x04, 0x00, 0x00, 0x00, 0x01}, []byte{0x04, 0x02, 0x00, 0x07}},
+		{"read coils", []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x03}, []byte{0x01, 0x01, 0x05}},
+		{"write single register", []byte{0x01, 0x06, 0x00, 0x0a, 0x00, 0x2a}, []byte{0x06, 0x00, 0x0a, 0x00, 0x2a}},
+		{"write multiple registers", []byte{0x01, 0x10, 0x00, 0x0b, 0x00, 0x02, 0x04, 0x00, 0x2b, 0x00, 0x2c},
+			[]byte{0x10, 0x00, 0x0b, 0x00, 0x02}},
+		{"write single coil", []byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00}, []byte{0x05, 0x00, 0x01, 0xff, 0x00}},
+		{"unsupported function", []byte{0x01, 0x07}, []byte{0x87, 0x01}},
+		{"exception as request", []byte{0x01, 0x85, 0x02}, []byte{0x85, 0x01}},
+		{"write exception as request", []byte{0x01, 0x86, 0x02}, []byte{0x86, 0x01}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := bank.Execute(request(t, tc.rtu...)); !bytes.Equal(got, tc.want) {
+				t.Fatalf("got [% x] want [% x]", got, tc.want)
+			}
+		})
+	}
+
+	for address, want := range map[uint16]uint16{10: 0x2a, 11: 0x2b, 12: 0x2c} {
+		if v, _ := bank.Holding(address); v != want {
+			t.Fatalf("holding [%d]: got [%d] want [%d]", address, v, want)
+		}
+	}
+	if !bank.coils[1] {
+		t.Fatalf("coil [1] not set")
+	}
+}
+
+func TestRegisterBankUnmappedZero(t *testing.T) {
+	bank := NewRegisterBank(&Config{UnmappedZero: true})
+	got := bank.Execute(request(t, 0x01, 0x03, 0x00, 0x64, 0x00, 0x01))
+	if want := []byte{0x03, 0x02, 0x00, 0x00}; !bytes.Equal(got, want) {
+		t.Fatalf("got [% x] want [% x]", got, want)
+	}
+}
+
+func TestEmulatorServe(t *testing.T) {
+	const serial uint32 = 2712345678
+	e := New(&Config{Serial: serial, SlaveID: 1, Holding: map[uint16]uint16{10: 42}})
+	proxy, logger := net.Pipe()
+	defer proxy.Close()
+	done := make(chan struct{})
+	go func() {
+		_ = e.Serve(logger)
+		close(done)
+	}()
+
+	want := map[byte][]byte{
+		1: protocol.PDUToRTU(1, []byte{0x85, 0x01}),             // exception sent as request
+		2: protocol.PDUToRTU(1, []byte{0x03, 0x02, 0x00, 0x2a}), // still serving
+	}
+	for seq, rtu := range map[byte][]byte{1: {0x01, 0x85, 0x02}, 2: {0x01, 0x03, 0x00, 0x0a, 0x00, 0x01}} {
+		req := protocol.NewV5Builder(protocol.ControlRequest).Serial(serial).Sequence(uint16(seq)).
+			Modbus(protocol.AppendCRC(rtu)).Marshal()
+		go proxy.Write(req)
+	}
+
+	proxy.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := protocol.NewFrameReader(proxy)
+	for range want {
+		resp, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("no response: %v", err)
+		}
+		if resp.LoggerSN() != serial || resp.Control() != protocol.ControlResponse {
+			t.Fatalf("response: serial [%d] control %s", resp.LoggerSN(), resp.Control())
+		}
+		if got := resp.ModbusFrame(); !bytes.Equal(got, want[resp.RequestSequence()]) {
+			t.Fatalf("sequence [%d]: got [% x] want [% x]", resp.RequestSequence(), got, want[resp.RequestSequence()])
+		}
+	}
+	proxy.Close()
+	<-done
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	cfg, err := LoadConfig("testdata/bank.json")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := &Config{
+		Serial: 2712345678, SlaveID: 2, Latency: 20 * time.Millisecond, ErrorRate: 0.1, Reconnect: 5 * time.Second,
+		Holding:  map[uint16]uint16{1: 258, 16: 16},
+		Input:    map[uint16]uint16{3: 7},
+		Coils:    map[uint16]bool{0: true, 2: false},
+		Discrete: map[uint16]bool{5: true},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("got %+v want %+v", cfg, want)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	"github.com/githubDante/go-solarman-proxy/server"
+)
+
+// listFlag repeatable string flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// cmdFlags command line flags. Only the flags set on the command line override the config
+type cmdFlags struct {
+	fs          *flag.FlagSet
+	configPath  *string
+	printConfig *bool
+
+	debug, silent, bcast, buffer, strict, pty, aliasEdit *bool
+
+	udp, unixPath, unixMode, unixOwner, dataAPI, ptyDir *string
+	loggersNet, unknownSerial, upstream                 *string
+	probeSlave, probeReg, defaultLogger                 *uint
+
+	clients, modbusTCP, modbusUnits, modbusRTU, loggerDial, upstreams, aliases listFlag
+}
+
+// register defines the flags on the given flag set (flag.CommandLine in main)
+func (f *cmdFlags) register(fs *flag.FlagSet) {
+	f.fs = fs
+	f.configPath = fs.String("config", "", "YAML configuration file (the flags override its values)")
+	f.printConfig = fs.Bool("print-config", false, "print the resolved configuration and exit")
+	f.debug = fs.Bool("debug", false, "enable debug logging")
+	f.silent = fs.Bool("silent", false, "enable silent mode")
+	f.bcast = fs.Bool("bcast", false, "enable the broadcast listener")
+	f.buffer = fs.Bool("buffered", false, "enable the logger write buffer (sequential client communication)")
+	f.strict = fs.Bool("strict", false, "drop frames with bad checksum, length, trailer or modbus crc")
+	f.udp = fs.String("udp", "", "listen address of the UDP clients listener, one V5 request per datagram (e.g. :8899)")
+	f.unixPath = fs.String("unix", "", "path of a unix domain socket for client connections")
+	f.unixMode = fs.String("unix-mode", "", "file mode of the unix socket (octal, e.g. 0660)")
+	f.unixOwner = fs.String("unix-owner", "", "owner of the unix socket <user>[:<group>]")
+	f.dataAPI = fs.String("data-api", "", "listen address of the HTTP API serving the captured data uploads and the serial aliases (e.g. :8898)")
+	f.aliasEdit = fs.Bool("data-api-aliases", false, "allow editing the serial aliases through the data API (no authentication)")
+	f.probeSlave = fs.Uint("probe-slave", 1, "modbus slave id used by the serial probe")
+	f.probeReg = fs.Uint("probe-register", 1, "holding register read by the serial probe")
+	f.pty = fs.Bool("pty", false, "create a Modbus RTU pseudo-terminal for every logger (linux only)")
+	f.ptyDir = fs.String("pty-dir", server.DefaultPtyDir, "directory for the pseudo-terminal links (<dir>/<serial>)")
+	f.loggersNet = fs.String("loggers-network", "", "network of the data-loggers listener: tcp (dual-stack), tcp4 or tcp6 (default: by address)")
+	f.unknownSerial = fs.String("unknown-serial", "broadcast", "handling of clients with serial 0 or unknown serial: broadcast, default, reject or pending")
+	f.defaultLogger = fs.Uint("default-logger", 0, "logger serial for clients with unknown serial (-unknown-serial default)")
+	f.upstream = fs.String("upstream", "", "cloud server receiving the frames initiated by the data-loggers <address>")
+	fs.Var(&f.aliases, "alias", "virtual serial used by the clients for a data-logger <virtual>=<logger serial> (repeatable)")
+	fs.Var(&f.clients, "clients", "clients listener [tcp|tcp4|tcp6://]<address>[=<logger serial>] (repeatable, default tcp4://0.0.0.0:8899)")
+	fs.Var(&f.modbusTCP, "modbus-tcp", "Modbus TCP listener <address>[=<logger serial>] (repeatable)")
+	fs.Var(&f.modbusUnits, "modbus-unit", "Modbus unit id to logger serial <unit>=<serial> (repeatable)")
+	fs.Var(&f.modbusRTU, "modbus-rtu", "Modbus RTU over TCP listener <address>=<logger serial> (repeatable)")
+	fs.Var(&f.upstreams, "upstream-logger", "cloud server for a single data-logger <logger serial>=<address> (repeatable)")
+	fs.Var(&f.loggerDial, "logger-dial", "data-logger in TCP-server mode dialed by the proxy <address>[=<logger serial>] (repeatable)")
+}
+
+// apply overrides the config values with the flags set on the command line
+//
+// Repeatable flags replace the lists of the config, the per serial ones (aliases, upstreams,
+// modbus units) replace only the entries with the same key
+func (f *cmdFlags) apply(c *Config) error {
+	set := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+
+	// -debug=false and -silent=false switch the level of the file back to info.
+	// Silent is applied last, it wins over -debug
+	for _, level := range []struct {
+		name string
+		on   *bool
+	}{{"debug", f.debug}, {"silent", f.silent}} {
+		if !set[level.name] {
+			continue
+		}
+		if *level.on {
+			c.Log = level.name
+		} else if c.Log == level.name {
+			c.Log = "info"
+		}
+	}
+	// flag -> config value
+	for name, v := range map[string][2]*bool{
+		"bcast": {f.bcast, &c.Broadcast}, "buffered": {f.buffer, &c.Buffered},
+		"strict": {f.strict, &c.Strict}, "pty": {f.pty, &c.Pty.Enabled},
+		"data-api-aliases": {f.aliasEdit, &c.DataAPIAliases},
+	} {
+		if set[name] {
+			*v[1] = *v[0]
+		}
+	}
+	for name, v := range map[string][2]*string{
+		"udp": {f.udp, &c.UDP}, "unix": {f.unixPath, &c.Unix.Path}, "unix-mode": {f.unixMode, &c.Unix.Mode},
+		"unix-owner": {f.unixOwner, &c.Unix.Owner}, "data-api": {f.dataAPI, &c.DataAPI}, "pty-dir": {f.ptyDir, &c.Pty.Dir},
+		"loggers-network": {f.loggersNet, &c.Network}, "unknown-serial": {f.unknownSerial, &c.UnknownSerial.Mode},
+		"upstream": {f.upstream, &c.Upstream},
+	} {
+		if set[name] {
+			*v[1] = *v[0]
+		}
+	}
+	if set["probe-slave"] {
+		if *f.probeSlave > 255 {
+			return errors.New("serial probe slave out of range")
+		}
+		c.Probe.Slave = byte(*f.probeSlave)
+	}
+	if set["probe-register"] {
+		if *f.probeReg > 65535 {
+			return errors.New("serial probe register out of range")
+		}
+		c.Probe.Register = uint16(*f.probeReg)
+	}
+	if set["default-logger"] {
+		if *f.defaultLogger > math.MaxUint32 {
+			return errors.New("default logger serial out of range")
+		}
+		c.UnknownSerial.DefaultLogger = uint32(*f.defaultLogger)
+	}
+	if set["clients"] {
+		c.Clients = f.clients
+	}
+	if set["modbus-tcp"] {
+		c.Modbus.TCP = f.modbusTCP
+	}
+	if set["modbus-rtu"] {
+		c.Modbus.RTU = f.modbusRTU
+	}
+	if set["logger-dial"] {
+		c.Dial = f.loggerDial
+	}
+	units, err := parseUnits(f.modbusUnits)
+	if err != nil {
+		return err
+	}
+	c.Modbus.Units = mergeMap(c.Modbus.Units, units)
+	upstreams, err := parseUpstreams(f.upstreams)
+	if err != nil {
+		return err
+	}
+	c.Upstreams = mergeMap(c.Upstreams, upstreams)
+	aliases, err := parseAliases(f.aliases)
+	if err != nil {
+		return err
+	}
+	c.Aliases = mergeMap(c.Aliases, aliases)
+	return nil
+}
+
+// mergeMap copies the entries of src over dst
+func mergeMap[K comparable, V any](dst, src map[K]V) map[K]V {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[K]V, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// parseSerial logger serial number
+func parseSerial(s string) (uint32, error) {
+	serial, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || serial == 0 {
+		return 0, fmt.Errorf("bad serial number <%s>", s)
+	}
+	return uint32(serial), nil
+}
+
+// parseUnits unit=serial pairs
+func parseUnits(values []string) (map[byte]uint32, error) {
+	units := make(map[byte]uint32)
+	for _, v := range values {
+		u, sn, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad unit mapping <%s>, expected unit=serial", v)
+		}
+		unit, err := strconv.ParseUint(u, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad unit id <%s>", u)
+		}
+		serial, err := parseSerial(sn)
+		if err != nil {
+			return nil, err
+		}
+		units[byte(unit)] = serial
+	}
+	return units, nil
+}
+
+// parseModbusListeners address[=serial] listeners. Listeners without serial use the unit map
+func parseModbusListeners(values []string, units map[byte]uint32) ([]server.ModbusListener, error) {
+	listeners := make([]server.ModbusListener, 0, len(values))
+	for _, v := range values {
+		addr, sn, fixed := strings.Cut(v, "=")
+		l := server.ModbusListener{Addr: addr, Route: client.ModbusRoute{Units: units}}
+		if fixed {
+			serial, err := parseSerial(sn)
+			if err != nil {
+				return nil, err
+			}
+			l.Route = client.ModbusRoute{Serial: serial}
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// parseRTUListeners address=serial listeners
+func parseRTUListeners(values []string) ([]server.ModbusListener, error) {
+	listeners := make([]server.ModbusListener, 0, len(values))
+	for _, v := range values {
+		addr, sn, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad RTU listener <%s>, expected address=serial", v)
+		}
+		serial, err := parseSerial(sn)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, server.ModbusListener{Addr: addr, Route: client.ModbusRoute{Serial: serial}, RTU: true})
+	}
+	return listeners, nil
+}
+
+// parseLoggerDials address[=serial] data-loggers in TCP-server mode
+func parseLoggerDials(values []string) ([]server.LoggerDial, error) {
+	dials := make([]server.LoggerDial, 0, len(values))
+	for _, v := range values {
+		addr, sn, fixed := strings.Cut(v, "=")
+		d := server.LoggerDial{Addr: addr}
+		if fixed {
+			serial, err := parseSerial(sn)
+			if err != nil {
+				return nil, err
+			}
+			d.Serial = serial
+		}
+		dials = append(dials, d)
+	}
+	return dials, nil
+}
+
+// parseUpstreams serial=address upstream overrides
+func parseUpstreams(values []string) (map[uint32]string, error) {
+	upstreams := make(map[uint32]string)
+	for _, v := range values {
+		sn, addr, ok := strings.Cut(v, "=")
+		if !ok || addr == "" {
+			return nil, fmt.Errorf("bad upstream <%s>, expected serial=address", v)
+		}
+		serial, err := parseSerial(sn)
+		if err != nil {
+			return nil, err
+		}
+		upstreams[serial] = addr
+	}
+	return upstreams, nil
+}
+
+// parseUnixListener socket path, octal file mode and user[:group] owner
+func parseUnixListener(path, mode, owner string) (server.UnixListener, error) {
+	l := server.UnixListener{Path: path}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0o777 {
+			return l, fmt.Errorf("bad socket mode <%s>", mode)
+		}
+		l.Mode = fs.FileMode(m)
+	}
+	l.Owner, l.Group, _ = strings.Cut(owner, ":")
+	return l, nil
+}
+
+// parseClientListeners [network://]address[=serial] clients listeners (tcp by default)
+func parseClientListeners(values []string) ([]server.ClientListener, error) {
+	listeners := make([]server.ClientListener, 0, len(values))
+	for _, v := range values {
+		v, sn, fixed := strings.Cut(v, "=")
+		l := server.ClientListener{Network: "tcp", Addr: v}
+		if network, addr, ok := strings.Cut(v, "://"); ok {
+			l.Network, l.Addr = network, addr
+		}
+		if fixed {
+			serial, err := parseSerial(sn)
+			if err != nil {
+				return nil, err
+			}
+			l.Serial = serial
+		}
+		if err := checkNetwork(l.Network); err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// checkNetwork allowed listener networks
+func checkNetwork(network string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return nil
+	}
+	return fmt.Errorf("bad network <%s>, expected tcp, tcp4 or tcp6", network)
+}
+
+// parseAliases virtual=physical serial aliases
+func parseAliases(values []string) (map[uint32]uint32, error) {
+	aliases := make(map[uint32]uint32)
+	for _, v := range values {
+		vs, ps, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad alias <%s>, expected virtual=physical", v)
+		}
+		virtual, err := parseSerial(vs)
+		if err != nil {
+			return nil, err
+		}
+		physical, err := parseSerial(ps)
+		if err != nil {
+			return nil, err
+		}
+		aliases[virtual] = physical
+	}
+	return aliases, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,17 +12,23 @@ func getT() string {
 	return time.Now().Format("[2006-01-02 15:04:05.000]")
 }
 
-var debug = false
-var silent = false
+var debug atomic.Bool
+var silent atomic.Bool
 
 // EnableDebug Activates debug messages
 func EnableDebug() {
-	debug = true
+	debug.Store(true)
 }
 
 // EnableSilent Enables silent mode. The logging functions will be completely disabled
 func EnableSilent() {
-	silent = true
+	silent.Store(true)
+}
+
+// SetLevel Changes the debug and silent modes at runtime
+func SetLevel(debugOn, silentOn bool) {
+	debug.Store(debugOn)
+	silent.Store(silentOn)
 }
 
 var green = color.New(color.FgHiGreen).FprintfFunc()
@@ -31,7 +38,7 @@ var blue = color.New(color.FgHiBlue).FprintfFunc()
 
 // LogInfof Info message
 func LogInfof(message string, args ...any) {
-	if silent {
+	if silent.Load() {
 		return
 	}
 	msg := fmt.Sprintf(message, args...)
@@ -40,7 +47,7 @@ func LogInfof(message string, args ...any) {
 
 // LogErrorf Error message
 func LogErrorf(format string, args ...any) {
-	if silent {
+	if silent.Load() {
 		return
 	}
 	msg := fmt.Sprintf(format, args...)
@@ -49,7 +56,7 @@ func LogErrorf(format string, args ...any) {
 
 // LogWarnf Warning message
 func LogWarnf(message string, args ...any) {
-	if silent {
+	if silent.Load() {
 		return
 	}
 	msg := fmt.Sprintf(message, args...)
@@ -58,7 +65,7 @@ func LogWarnf(message string, args ...any) {
 
 // LogDebugf Debug message
 func LogDebugf(message string, args ...any) {
-	if !debug || silent {
+	if !debug.Load() || silent.Load() {
 		return
 	}
 	msg := fmt.Sprintf(message, args...)
@@ -1,52 +1,87 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	log "github.com/githubDante/go-solarman-proxy/logging"
 	"os"
 	"strconv"
 
-	"github.com/githubDante/go-solarman-proxy/server"
+	"gopkg.in/yaml.v3"
 )
 
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
 		fmt.Fprintf(flag.CommandLine.Output(), "%s [flags] <IP Address> <Port>\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "%s -config <file> [flags] [<IP Address> <Port>]\n", os.Args[0])
 		fmt.Fprintf(flag.CommandLine.Output(), "Flags:\n")
 		flag.PrintDefaults()
 	}
-	debug := flag.Bool("debug", false, "enable debug logging")
-	silent := flag.Bool("silent", false, "enable silent mode")
-	bcast := flag.Bool("bcast", false, "enable the broadcast listener")
-	buffer := flag.Bool("buffered", false, "enable the logger write buffer (sequential client communication)")
+	var flags cmdFlags
+	flags.register(flag.CommandLine)
 	flag.Parse()
 	args := flag.Args()
 
-	if len(args) < 2 {
+	if *flags.configPath == "" && len(args) < 2 {
 		log.LogErrorf("[%s] requires IPAddress and port\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	ip := args[0]
-	port, err := strconv.ParseUint(args[1], 10, 64)
-	if err != nil || port > 65535 {
-		log.LogErrorf("[%s] port error...\n", os.Args[0])
+	cfg, err := resolveConfig(&flags, args)
+	if err != nil {
+		log.LogErrorf("[%s] %s\n", os.Args[0], err.Error())
 		os.Exit(1)
 	}
-	if *debug {
-		log.EnableDebug()
-	}
-	if *silent {
-		log.EnableSilent()
+	if *flags.printConfig {
+		out, _ := yaml.Marshal(cfg)
+		os.Stdout.Write(out)
+		return
 	}
-	proxy := server.NewProxy(ip, int(port))
-	err = proxy.Serve(*bcast, *buffer)
+
+	setLogLevel(cfg.Log)
+	proxy := newProxy(cfg)
+	err = proxy.Serve(cfg.Broadcast, cfg.Buffered)
 	if err != nil {
 		log.LogErrorf("Proxy start error: %s\n", err.Error())
 		os.Exit(1)
 	}
 
+	go watchReload(proxy, cfg, &flags, args)
 	proxy.Wait()
 }
+
+// resolveConfig loads the config file (if any) and applies the flags and the positional arguments
+func resolveConfig(flags *cmdFlags, args []string) (*Config, error) {
+	cfg := defaultConfig()
+	var err error
+	if *flags.configPath != "" {
+		cfg, err = loadConfig(*flags.configPath)
+		if err != nil {
+			return nil, fmt.Errorf("config error: %w", err)
+		}
+	}
+	if err = flags.apply(cfg); err != nil {
+		return nil, err
+	}
+	if len(args) >= 2 {
+		port, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil || port == 0 || port > 65535 {
+			return nil, errors.New("port error")
+		}
+		cfg.Address, cfg.Port = args[0], uint16(port)
+	}
+	if err = cfg.mergeLoggers(); err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+	if err = cfg.validate(); err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+	return cfg, nil
+}
+
+// setLogLevel applies the log setting (info, debug or silent)
+func setLogLevel(level string) {
+	log.SetLevel(level == "debug", level == "silent")
+}
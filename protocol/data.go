@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// frame type (1) + sensor type (2) + total working time (4) + power on time (4) + offset time (4)
+const dataPrefixLen = 15
+
+// DataUpload inverter data snapshot pushed by the data-logger (0x4210)
+//
+// The layout of the data block depends on the inverter model, so it is kept raw
+type DataUpload struct {
+	Serial      uint32
+	Received    time.Time
+	FrameType   byte
+	SensorType  uint16
+	WorkingTime uint32
+	PowerOnTime uint32
+	OffsetTime  uint32
+	Data        []byte
+}
+
+// ParseDataUpload decodes a data frame
+func ParseDataUpload(f *V5Frame, received time.Time) (*DataUpload, error) {
+	if f.Control() != ControlData {
+		return nil, fmt.Errorf("%w: %s frame", ErrNotDataFrame, f.Control())
+	}
+	payload := f.Payload()
+	if len(payload) < dataPrefixLen {
+		return nil, fmt.Errorf("%w: [%d bytes] payload", ErrShortDataUpload, len(payload))
+	}
+	data := make([]byte, len(payload)-dataPrefixLen)
+	copy(data, payload[dataPrefixLen:])
+	return &DataUpload{
+		Serial:      f.LoggerSN(),
+		Received:    received,
+		FrameType:   payload[0],
+		SensorType:  binary.LittleEndian.Uint16(payload[1:3]),
+		WorkingTime: binary.LittleEndian.Uint32(payload[3:7]),
+		PowerOnTime: binary.LittleEndian.Uint32(payload[7:11]),
+		OffsetTime:  binary.LittleEndian.Uint32(payload[11:15]),
+		Data:        data,
+	}, nil
+}
+
+// Registers the data block as big-endian 16-bit words (register values)
+func (d *DataUpload) Registers() []uint16 {
+	regs := make([]uint16, len(d.Data)/2)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(d.Data[i*2:])
+	}
+	return regs
+}
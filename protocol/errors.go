@@ -0,0 +1,29 @@
+package protocol
+
+import (
+	"errors"
+)
+
+// Frame decoding errors
+var (
+	ErrShortFrame = errors.New("frame too short")
+	ErrFrameStart = errors.New("bad frame start")
+	ErrFrameEnd   = errors.New("bad frame end")
+)
+
+// Strict validation errors (see V5Frame.Validate)
+var (
+	ErrChecksum  = errors.New("checksum mismatch")
+	ErrLength    = errors.New("length field mismatch")
+	ErrTrailer   = errors.New("trailer misplaced")
+	ErrModbusCRC = errors.New("modbus crc mismatch")
+)
+
+// Payload decoding errors
+var (
+	ErrNoModbus        = errors.New("no modbus frame")
+	ErrShortModbus     = errors.New("modbus frame too short")
+	ErrModbusLength    = errors.New("modbus length mismatch")
+	ErrNotDataFrame    = errors.New("not a data frame")
+	ErrShortDataUpload = errors.New("data frame too short")
+)
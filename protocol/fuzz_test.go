@@ -0,0 +1,154 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func seedFrames(f *testing.F) {
+	request := &ModbusRequest{Serial: 2712345678, Sequence: 0x0042, SlaveID: 1,
+		Function: FuncReadHoldingRegisters, Address: 0x0010, Quantity: 4}
+	write := &ModbusRequest{Serial: 2712345678, SlaveID: 1,
+		Function: FuncWriteMultipleRegisters, Address: 0x0020, Values: []uint16{1, 2, 3}}
+	response := NewV5Builder(ControlResponse).Serial(2712345678).Sequence(0x1142).
+		Modbus(AppendCRC([]byte{0x01, 0x03, 0x04, 0x00, 0x01, 0x00, 0x02})).Marshal()
+	exception := NewV5Builder(ControlResponse).Serial(2712345678).
+		Modbus(AppendCRC([]byte{0x01, 0x83, 0x02})).Marshal()
+	data := NewV5Builder(ControlData).Serial(2712345678).Payload(make([]byte, 40)).Marshal()
+	holding, _ := hex.DecodeString("a517001045000000000000020000000000000000000000000000010300010001d5ca1315")
+
+	f.Add(request.ToBytes())
+	f.Add(write.ToBytes())
+	f.Add(response)
+	f.Add(exception)
+	f.Add(data)
+	f.Add(holding)
+	f.Add(TimeResponse(request.Frame(), time.Unix(1700000000, 0)))
+	f.Add([]byte{V5Start, 0xff, 0xff, V5End})
+	f.Add([]byte{})
+}
+
+func FuzzNewV5Frame(f *testing.F) {
+	seedFrames(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := NewV5Frame(data)
+		if err != nil {
+			if !errors.Is(err, ErrShortFrame) && !errors.Is(err, ErrFrameStart) && !errors.Is(err, ErrFrameEnd) {
+				t.Fatalf("unexpected error class: %v", err)
+			}
+			return
+		}
+		if !bytes.Equal(frame.Bytes(), data) {
+			t.Fatalf("frame bytes differ from input")
+		}
+		_ = frame.Validate()
+		_ = frame.Control().String()
+		_ = frame.ModbusFrame()
+		if m, err := frame.Modbus(); err == nil {
+			_ = m.CRCOK()
+			_ = m.Registers()
+			_ = m.String()
+		}
+		if d, err := ParseDataUpload(frame, time.Now()); err == nil {
+			_ = d.Registers()
+		}
+		frame.SetRequestSequence(frame.RequestSequence() + 1)
+		if frame.ChecksumOK() != (frame.CalculatedChecksum() == frame.Checksum()) {
+			t.Fatalf("checksum state inconsistent")
+		}
+		_ = TimeResponse(frame, time.Now())
+	})
+}
+
+func FuzzFrameReader(f *testing.F) {
+	seedFrames(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := NewFrameReader(bytes.NewReader(data))
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				return
+			}
+			if int(frame.PayloadLen())+headerLen+2 != frame.Length() {
+				t.Fatalf("frame length does not match the length field")
+			}
+		}
+	})
+}
+
+func FuzzParseModbusRequest(f *testing.F) {
+	f.Add(AppendCRC([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01}))
+	f.Add(AppendCRC([]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x05}))
+	f.Add(AppendCRC([]byte{0x01, 0x05, 0x00, 0x01, 0xff, 0x00}))
+	f.Add([]byte{0x01, 0x83})
+	f.Fuzz(func(t *testing.T, rtu []byte) {
+		m, err := ParseModbusRequest(rtu)
+		if err != nil {
+			if !errors.Is(err, ErrShortModbus) && !errors.Is(err, ErrModbusLength) {
+				t.Fatalf("unexpected error class: %v", err)
+			}
+			return
+		}
+		_ = m.CRCOK()
+		_ = m.Registers()
+		_ = m.String()
+	})
+}
+
+func FuzzParseModbusResponse(f *testing.F) {
+	f.Add(AppendCRC([]byte{0x01, 0x03, 0x02, 0x12, 0x34}))
+	f.Add(AppendCRC([]byte{0x01, 0x83, 0x02}))
+	f.Add(AppendCRC([]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x02}))
+	f.Add(AppendCRC([]byte{0x01, 0x01, 0x01, 0x05}))
+	f.Fuzz(func(t *testing.T, rtu []byte) {
+		m, err := ParseModbusResponse(rtu)
+		if err != nil {
+			if !errors.Is(err, ErrShortModbus) && !errors.Is(err, ErrModbusLength) {
+				t.Fatalf("unexpected error class: %v", err)
+			}
+			return
+		}
+		_ = m.CRCOK()
+		_ = m.Registers()
+		_ = m.String()
+	})
+}
+
+func FuzzReadMBAP(f *testing.F) {
+	f.Add((&MBAPFrame{TransactionID: 1, UnitID: 1, PDU: []byte{0x03, 0x00, 0x01, 0x00, 0x02}}).Bytes())
+	f.Add([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bytes.NewReader(data)
+		for {
+			m, err := ReadMBAP(r)
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(m.Bytes()[7:], m.PDU) {
+				t.Fatalf("re-encoded PDU differs")
+			}
+		}
+	})
+}
+
+func FuzzRTUReader(f *testing.F) {
+	f.Add(AppendCRC([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01}))
+	f.Add(AppendCRC([]byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x05}))
+	f.Add(append([]byte{0xff, 0x10}, AppendCRC([]byte{0x01, 0x06, 0x00, 0x01, 0x00, 0x05})...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := NewRTUReader(bytes.NewReader(data))
+		for {
+			rtu, err := reader.ReadRequest()
+			if err != nil {
+				return
+			}
+			if !CRCValid(rtu) {
+				t.Fatalf("request with bad crc returned")
+			}
+			_, _ = ParseModbusRequest(rtu)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package protocol
+
+/*
+Modbus TCP (MBAP) framing
+
+	Transaction id  2 bytes
+	Protocol id     2 bytes  always 0
+	Length          2 bytes  unit id + PDU
+	Unit id         1 byte
+	PDU             function code + data
+
+All values are big-endian. There is no CRC.
+*/
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	mbapHeaderLen = 7
+	maxMBAPLen    = 254 // unit id + max PDU (253)
+)
+
+// MBAPFrame Modbus TCP application data unit
+type MBAPFrame struct {
+	TransactionID uint16
+	UnitID        byte
+	PDU           []byte
+}
+
+// ReadMBAP reads a single Modbus TCP frame
+//
+// The returned error wraps ErrModbusLength when the header is invalid. The stream cannot
+// be resynchronised in that case
+func ReadMBAP(r io.Reader) (*MBAPFrame, error) {
+	header := make([]byte, mbapHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	protocolID := binary.BigEndian.Uint16(header[2:4])
+	length := int(binary.BigEndian.Uint16(header[4:6]))
+	if protocolID != 0 || length < 2 || length > maxMBAPLen {
+		return nil, fmt.Errorf("%w: mbap protocol [%d] length [%d]", ErrModbusLength, protocolID, length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(r, pdu); err != nil {
+		return nil, err
+	}
+	return &MBAPFrame{
+		TransactionID: binary.BigEndian.Uint16(header[0:2]),
+		UnitID:        header[6],
+		PDU:           pdu,
+	}, nil
+}
+
+// Bytes encodes the frame
+func (m *MBAPFrame) Bytes() []byte {
+	frame := make([]byte, mbapHeaderLen, mbapHeaderLen+len(m.PDU))
+	binary.BigEndian.PutUint16(frame[0:2], m.TransactionID)
+	binary.BigEndian.PutUint16(frame[4:6], uint16(len(m.PDU)+1))
+	frame[6] = m.UnitID
+	return append(frame, m.PDU...)
+}
+
+// PDUToRTU builds a Modbus RTU frame (CRC included) from a PDU
+func PDUToRTU(slave byte, pdu []byte) []byte {
+	rtu := make([]byte, 0, len(pdu)+3)
+	rtu = append(rtu, slave)
+	rtu = append(rtu, pdu...)
+	return AppendCRC(rtu)
+}
+
+// RTUToPDU strips the slave id and the CRC from a Modbus RTU frame
+func RTUToPDU(rtu []byte) ([]byte, error) {
+	if len(rtu) < minModbusLen {
+		return nil, fmt.Errorf("%w: [%d bytes]", ErrShortModbus, len(rtu))
+	}
+	return rtu[1 : len(rtu)-2], nil
+}
+
+// ExceptionPDU Modbus exception PDU for the given function
+func ExceptionPDU(function ModbusFunction, code ModbusException) []byte {
+	return []byte{byte(function | exceptionFlag), byte(code)}
+}
@@ -0,0 +1,288 @@
+package protocol
+
+/*
+Modbus RTU frames carried inside V5 request/response payloads
+
+	Request  (read)         slave | function | address(2) | quantity(2) | crc(2)
+	Request  (write single) slave | function | address(2) | value(2) | crc(2)
+	Request  (write multi)  slave | function | address(2) | quantity(2) | byte count | values | crc(2)
+	Response (read)         slave | function | byte count | values | crc(2)
+	Response (write single) slave | function | address(2) | value(2) | crc(2)
+	Response (write multi)  slave | function | address(2) | quantity(2) | crc(2)
+	Exception               slave | function|0x80 | exception code | crc(2)
+
+Address, quantity and register values are big-endian, the CRC is little-endian.
+*/
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const minModbusLen = 4 // slave + function + crc
+
+// ModbusFunction Modbus function code
+type ModbusFunction byte
+
+const (
+	FuncReadCoils              ModbusFunction = 0x01
+	FuncReadDiscreteInputs     ModbusFunction = 0x02
+	FuncReadHoldingRegisters   ModbusFunction = 0x03
+	FuncReadInputRegisters     ModbusFunction = 0x04
+	FuncWriteSingleCoil        ModbusFunction = 0x05
+	FuncWriteSingleRegister    ModbusFunction = 0x06
+	FuncWriteMultipleCoils     ModbusFunction = 0x0f
+	FuncWriteMultipleRegisters ModbusFunction = 0x10
+
+	exceptionFlag ModbusFunction = 0x80
+)
+
+func (m ModbusFunction) String() string {
+	switch m {
+	case FuncReadCoils:
+		return "ReadCoils"
+	case FuncReadDiscreteInputs:
+		return "ReadDiscreteInputs"
+	case FuncReadHoldingRegisters:
+		return "ReadHoldingRegisters"
+	case FuncReadInputRegisters:
+		return "ReadInputRegisters"
+	case FuncWriteSingleCoil:
+		return "WriteSingleCoil"
+	case FuncWriteSingleRegister:
+		return "WriteSingleRegister"
+	case FuncWriteMultipleCoils:
+		return "WriteMultipleCoils"
+	case FuncWriteMultipleRegisters:
+		return "WriteMultipleRegisters"
+	default:
+		return fmt.Sprintf("Function(0x%02x)", byte(m))
+	}
+}
+
+// IsRead reports whether the function reads data from the device
+func (m ModbusFunction) IsRead() bool {
+	return m >= FuncReadCoils && m <= FuncReadInputRegisters
+}
+
+// IsWrite reports whether the function writes data to the device
+func (m ModbusFunction) IsWrite() bool {
+	switch m {
+	case FuncWriteSingleCoil, FuncWriteSingleRegister, FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+		return true
+	}
+	return false
+}
+
+// ModbusException Modbus exception code
+type ModbusException byte
+
+const (
+	ExIllegalFunction   ModbusException = 0x01
+	ExIllegalAddress    ModbusException = 0x02
+	ExIllegalValue      ModbusException = 0x03
+	ExDeviceFailure     ModbusException = 0x04
+	ExAcknowledge       ModbusException = 0x05
+	ExDeviceBusy        ModbusException = 0x06
+	ExGatewayPathFailed ModbusException = 0x0a
+	ExGatewayNoResponse ModbusException = 0x0b
+)
+
+func (e ModbusException) String() string {
+	switch e {
+	case ExIllegalFunction:
+		return "IllegalFunction"
+	case ExIllegalAddress:
+		return "IllegalDataAddress"
+	case ExIllegalValue:
+		return "IllegalDataValue"
+	case ExDeviceFailure:
+		return "ServerDeviceFailure"
+	case ExAcknowledge:
+		return "Acknowledge"
+	case ExDeviceBusy:
+		return "ServerDeviceBusy"
+	case ExGatewayPathFailed:
+		return "GatewayPathUnavailable"
+	case ExGatewayNoResponse:
+		return "GatewayTargetNoResponse"
+	default:
+		return fmt.Sprintf("Exception(0x%02x)", byte(e))
+	}
+}
+
+// ModbusFrame decoded Modbus RTU frame
+type ModbusFrame struct {
+	SlaveID   byte
+	Function  ModbusFunction
+	Exception ModbusException
+	// Start register/coil. Not available in read responses
+	Address uint16
+	// Number of registers/coils. For read responses it is derived from the byte count (registers only)
+	Quantity uint16
+	// Register values/coil bits as sent on the wire
+	Data []byte
+	CRC  uint16
+	raw  []byte
+}
+
+// ParseModbusRequest decodes a Modbus RTU request frame
+//
+// The returned error wraps ErrShortModbus or ErrModbusLength
+func ParseModbusRequest(rtu []byte) (*ModbusFrame, error) {
+	m, body, err := newModbusFrame(rtu)
+	if err != nil || m.Exception != 0 {
+		return m, err
+	}
+	switch {
+	case m.Function.IsRead(), m.Function == FuncWriteSingleCoil, m.Function == FuncWriteSingleRegister:
+		if len(body) != 4 {
+			return nil, fmt.Errorf("%w: %s request [%d bytes]", ErrModbusLength, m.Function, len(rtu))
+		}
+		m.Address = binary.BigEndian.Uint16(body[0:2])
+		if m.Function.IsRead() {
+			m.Quantity = binary.BigEndian.Uint16(body[2:4])
+		} else {
+			m.Quantity = 1
+			m.Data = body[2:4]
+		}
+	case m.Function == FuncWriteMultipleCoils, m.Function == FuncWriteMultipleRegisters:
+		if len(body) < 5 || int(body[4]) != len(body)-5 {
+			return nil, fmt.Errorf("%w: %s request [%d bytes]", ErrModbusLength, m.Function, len(rtu))
+		}
+		m.Address = binary.BigEndian.Uint16(body[0:2])
+		m.Quantity = binary.BigEndian.Uint16(body[2:4])
+		m.Data = body[5:]
+	default:
+		m.Data = body
+	}
+	return m, nil
+}
+
+// ParseModbusResponse decodes a Modbus RTU response frame
+//
+// The returned error wraps ErrShortModbus or ErrModbusLength
+func ParseModbusResponse(rtu []byte) (*ModbusFrame, error) {
+	m, body, err := newModbusFrame(rtu)
+	if err != nil || m.Exception != 0 {
+		return m, err
+	}
+	switch {
+	case m.Function.IsRead():
+		if len(body) < 1 || int(body[0]) != len(body)-1 {
+			return nil, fmt.Errorf("%w: %s response [%d bytes]", ErrModbusLength, m.Function, len(rtu))
+		}
+		m.Data = body[1:]
+		if m.Function == FuncReadHoldingRegisters || m.Function == FuncReadInputRegisters {
+			m.Quantity = uint16(len(m.Data) / 2)
+		}
+	case m.Function.IsWrite():
+		if len(body) != 4 {
+			return nil, fmt.Errorf("%w: %s response [%d bytes]", ErrModbusLength, m.Function, len(rtu))
+		}
+		m.Address = binary.BigEndian.Uint16(body[0:2])
+		if m.Function == FuncWriteSingleCoil || m.Function == FuncWriteSingleRegister {
+			m.Quantity = 1
+			m.Data = body[2:4]
+		} else {
+			m.Quantity = binary.BigEndian.Uint16(body[2:4])
+		}
+	default:
+		m.Data = body
+	}
+	return m, nil
+}
+
+// newModbusFrame decodes the common part of the frame and returns the bytes between
+// the function code and the CRC
+func newModbusFrame(rtu []byte) (*ModbusFrame, []byte, error) {
+	if len(rtu) < minModbusLen {
+		return nil, nil, fmt.Errorf("%w: [%d bytes]", ErrShortModbus, len(rtu))
+	}
+	m := &ModbusFrame{
+		SlaveID:  rtu[0],
+		Function: ModbusFunction(rtu[1]),
+		CRC:      binary.LittleEndian.Uint16(rtu[len(rtu)-2:]),
+		raw:      rtu,
+	}
+	body := rtu[2 : len(rtu)-2]
+	if m.Function&exceptionFlag != 0 {
+		if len(body) != 1 {
+			return nil, nil, fmt.Errorf("%w: exception [%d bytes]", ErrModbusLength, len(rtu))
+		}
+		m.Function &^= exceptionFlag
+		m.Exception = ModbusException(body[0])
+	}
+	return m, body, nil
+}
+
+// CRCOK Modbus CRC verification
+func (m *ModbusFrame) CRCOK() bool {
+	return CRC16(m.raw[:len(m.raw)-2]) == m.CRC
+}
+
+// IsException reports whether the frame is an exception response
+func (m *ModbusFrame) IsException() bool {
+	return m.Exception != 0
+}
+
+// Registers register values carried by the frame
+func (m *ModbusFrame) Registers() []uint16 {
+	regs := make([]uint16, len(m.Data)/2)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(m.Data[i*2:])
+	}
+	return regs
+}
+
+// Bytes the raw RTU frame
+func (m *ModbusFrame) Bytes() []byte {
+	return m.raw
+}
+
+func (m *ModbusFrame) String() string {
+	if m.IsException() {
+		return fmt.Sprintf("slave [%d] %s exception %s", m.SlaveID, m.Function, m.Exception)
+	}
+	return fmt.Sprintf("slave [%d] %s address [%d] quantity [%d]", m.SlaveID, m.Function, m.Address, m.Quantity)
+}
+
+// CRCValid Modbus CRC verification of a raw RTU frame
+func CRCValid(rtu []byte) bool {
+	if len(rtu) < minModbusLen {
+		return false
+	}
+	return CRC16(rtu[:len(rtu)-2]) == binary.LittleEndian.Uint16(rtu[len(rtu)-2:])
+}
+
+// CRC16 Modbus CRC16
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// AppendCRC appends the Modbus CRC16 to an RTU frame
+func AppendCRC(rtu []byte) []byte {
+	return binary.LittleEndian.AppendUint16(rtu, CRC16(rtu))
+}
+
+// Modbus decodes the Modbus RTU frame embedded in request/response frames
+func (f *V5Frame) Modbus() (*ModbusFrame, error) {
+	rtu := f.ModbusFrame()
+	if rtu == nil {
+		return nil, fmt.Errorf("%w: %s frame", ErrNoModbus, f.Control())
+	}
+	if f.Control().IsResponse() {
+		return ParseModbusResponse(rtu)
+	}
+	return ParseModbusRequest(rtu)
+}
@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseModbus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response bool
+		rtu      []byte
+		want     ModbusFrame
+		regs     []uint16
+	}{
+		{"read holding request", false, []byte{0x01, 0x03, 0x00, 0x10, 0x00, 0x04},
+			ModbusFrame{SlaveID: 1, Function: FuncReadHoldingRegisters, Address: 0x10, Quantity: 4}, []uint16{}},
+		{"read holding response", true, []byte{0x01, 0x03, 0x04, 0x00, 0x01, 0x12, 0x34},
+			ModbusFrame{SlaveID: 1, Function: FuncReadHoldingRegisters, Quantity: 2, Data: []byte{0x00, 0x01, 0x12, 0x34}},
+			[]uint16{0x0001, 0x1234}},
+		{"write single request", false, []byte{0x02, 0x06, 0x00, 0x2a, 0xbe, 0xef},
+			ModbusFrame{SlaveID: 2, Function: FuncWriteSingleRegister, Address: 0x2a, Quantity: 1, Data: []byte{0xbe, 0xef}},
+			[]uint16{0xbeef}},
+		{"write single response", true, []byte{0x02, 0x06, 0x00, 0x2a, 0xbe, 0xef},
+			ModbusFrame{SlaveID: 2, Function: FuncWriteSingleRegister, Address: 0x2a, Quantity: 1, Data: []byte{0xbe, 0xef}},
+			[]uint16{0xbeef}},
+		{"write multiple request", false, []byte{0x01, 0x10, 0x00, 0x20, 0x00, 0x02, 0x04, 0x00, 0x0a, 0x01, 0x02},
+			ModbusFrame{SlaveID: 1, Function: FuncWriteMultipleRegisters, Address: 0x20, Quantity: 2, Data: []byte{0x00, 0x0a, 0x01, 0x02}},
+			[]uint16{0x000a, 0x0102}},
+		{"write multiple response", true, []byte{0x01, 0x10, 0x00, 0x20, 0x00, 0x02},
+			ModbusFrame{SlaveID: 1, Function: FuncWriteMultipleRegisters, Address: 0x20, Quantity: 2}, []uint16{}},
+		{"exception response", true, []byte{0x01, 0x83, 0x02},
+			ModbusFrame{SlaveID: 1, Function: FuncReadHoldingRegisters, Exception: ExIllegalAddress}, []uint16{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rtu := AppendCRC(tc.rtu)
+			parse := ParseModbusRequest
+			if tc.response {
+				parse = ParseModbusResponse
+			}
+			m, err := parse(rtu)
+			if err != nil {
+				t.Fatalf("parse [% x]: %v", rtu, err)
+			}
+			if !m.CRCOK() || !bytes.Equal(m.Bytes(), rtu) {
+				t.Fatalf("crc ok %v raw [% x]", m.CRCOK(), m.Bytes())
+			}
+			got := *m
+			got.CRC, got.raw = 0, nil
+			if len(got.Data) == 0 {
+				got.Data = nil
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v want %+v", got, tc.want)
+			}
+			if regs := m.Registers(); !reflect.DeepEqual(regs, tc.regs) {
+				t.Fatalf("registers: got %04x want %04x", regs, tc.regs)
+			}
+			if m.IsException() != (tc.want.Exception != 0) {
+				t.Fatalf("IsException %v", m.IsException())
+			}
+		})
+	}
+}
+
+func TestParseModbusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response bool
+		rtu      []byte
+		want     error
+	}{
+		{"short", false, []byte{0x01, 0x03, 0x00}, ErrShortModbus},
+		{"read request length", false, AppendCRC([]byte{0x01, 0x03, 0x00, 0x10, 0x00}), ErrModbusLength},
+		{"write multiple byte count", false, AppendCRC([]byte{0x01, 0x10, 0x00, 0x20, 0x00, 0x02, 0x04, 0x00, 0x0a}), ErrModbusLength},
+		{"read response byte count", true, AppendCRC([]byte{0x01, 0x03, 0x04, 0x00, 0x01}), ErrModbusLength},
+		{"exception length", true, AppendCRC([]byte{0x01, 0x83, 0x02, 0x00}), ErrModbusLength},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			parse := ParseModbusRequest
+			if tc.response {
+				parse = ParseModbusResponse
+			}
+			if _, err := parse(tc.rtu); !errors.Is(err, tc.want) {
+				t.Fatalf("got %v want %v", err, tc.want)
+			}
+		})
+	}
+}
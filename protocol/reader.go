@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// maxPayloadLen upper limit for the length field. Bigger values are treated as junk
+	maxPayloadLen = 1024
+	readChunk     = 2048
+)
+
+// FrameReader splits a byte stream into V5 frames
+//
+// The length field is used for frame delimiting, so coalesced frames are split and partial
+// ones are buffered until complete. Bytes which cannot be a frame start are discarded.
+type FrameReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+// NewFrameReader creates a FrameReader on top of r (usually a net.Conn)
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{
+		r:   r,
+		buf: make([]byte, 0, readChunk),
+	}
+}
+
+// ReadFrame blocks until a complete frame is available or the underlying reader fails
+func (fr *FrameReader) ReadFrame() (*V5Frame, error) {
+	for {
+		if frame := fr.next(); frame != nil {
+			return frame, nil
+		}
+		chunk := make([]byte, readChunk)
+		n, err := fr.r.Read(chunk)
+		if n > 0 {
+			fr.buf = append(fr.buf, chunk[:n]...)
+			continue
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+}
+
+// Buffered number of bytes waiting for frame completion
+func (fr *FrameReader) Buffered() int {
+	return len(fr.buf)
+}
+
+// next extracts the first complete frame from the buffer
+func (fr *FrameReader) next() *V5Frame {
+	for {
+		start := bytes.IndexByte(fr.buf, V5Start)
+		if start < 0 {
+			fr.buf = fr.buf[:0]
+			return nil
+		}
+		fr.buf = fr.buf[start:]
+		if len(fr.buf) < 3 {
+			return nil
+		}
+		pLen := int(binary.LittleEndian.Uint16(fr.buf[1:3]))
+		if pLen > maxPayloadLen {
+			fr.buf = fr.buf[1:] // resync
+			continue
+		}
+		total := headerLen + pLen + 2
+		if len(fr.buf) < total {
+			// a junk start byte with a plausible length would hold back the frames after it
+			if later := fr.completeFrame(); later > 0 {
+				fr.buf = fr.buf[later:] // resync
+				continue
+			}
+			return nil
+		}
+		if fr.buf[total-1] != V5End {
+			fr.buf = fr.buf[1:] // resync
+			continue
+		}
+		frame, err := NewV5Frame(fr.buf[:total])
+		fr.buf = append(fr.buf[:0], fr.buf[total:]...)
+		if err == nil {
+			return frame
+		}
+	}
+}
+
+// completeFrame offset of the first complete frame (trailer and checksum verified) after
+// the start of the buffer, 0 if there is none
+func (fr *FrameReader) completeFrame() int {
+	for i := 1; i < len(fr.buf); i++ {
+		next := bytes.IndexByte(fr.buf[i:], V5Start)
+		if next < 0 {
+			return 0
+		}
+		i += next
+		if len(fr.buf)-i < 3 {
+			return 0
+		}
+		total := headerLen + int(binary.LittleEndian.Uint16(fr.buf[i+1:i+3])) + 2
+		if len(fr.buf)-i < total || fr.buf[i+total-1] != V5End {
+			continue
+		}
+		if frame, err := NewV5Frame(fr.buf[i : i+total]); err == nil && frame.ChecksumOK() {
+			return i
+		}
+	}
+	return 0
+}
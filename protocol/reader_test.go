@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// chunkReader returns one chunk per Read call
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+func testFrame(seq uint16) []byte {
+	return NewV5Builder(ControlResponse).Serial(2712345678).Sequence(seq).
+		Modbus(AppendCRC([]byte{0x01, 0x03, 0x02, 0x00, byte(seq)})).Marshal()
+}
+
+func TestFrameReader(t *testing.T) {
+	f1, f2 := testFrame(0x0101), testFrame(0x0202)
+	badEnd := testFrame(0x0303)
+	badEnd[len(badEnd)-1] = 0x00
+	bigLen := []byte{V5Start, 0xff, 0xff, 0x10, 0x45}
+
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	for _, tc := range []struct {
+		name   string
+		chunks [][]byte
+		want   []uint16
+	}{
+		{"single", [][]byte{f1}, []uint16{0x0101}},
+		{"coalesced", [][]byte{join(f1, f2)}, []uint16{0x0101, 0x0202}},
+		{"split in header", [][]byte{f1[:2], f1[2:]}, []uint16{0x0101}},
+		{"split in payload", [][]byte{f1[:15], f1[15:20], f1[20:]}, []uint16{0x0101}},
+		{"split across frames", [][]byte{join(f1, f2[:5]), f2[5:]}, []uint16{0x0101, 0x0202}},
+		{"junk prefix", [][]byte{join([]byte{0x00, 0x15, 0xff}, f1)}, []uint16{0x0101}},
+		{"junk between", [][]byte{join(f1, []byte{0x01, 0x02}, f2)}, []uint16{0x0101, 0x0202}},
+		{"bad trailer", [][]byte{join(badEnd, f1)}, []uint16{0x0101}},
+		{"bad length", [][]byte{join(bigLen, f1)}, []uint16{0x0101}},
+		{"stalled junk start", [][]byte{join([]byte{V5Start, 0xe8, 0x03}, f1)}, []uint16{0x0101}},
+		{"stalled junk start split", [][]byte{{V5Start, 0xe8, 0x03}, f1[:10], f1[10:], f2}, []uint16{0x0101, 0x0202}},
+		{"junk only", [][]byte{{0x01, 0x02, 0x03}}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fr := NewFrameReader(&chunkReader{chunks: tc.chunks})
+			var got []uint16
+			for {
+				frame, err := fr.ReadFrame()
+				if err != nil {
+					if !errors.Is(err, io.EOF) {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					break
+				}
+				got = append(got, frame.Sequence())
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("frames: got %04x want %04x", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("frames: got %04x want %04x", got, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"encoding/binary"
+)
+
+// ModbusRequest Modbus RTU request addressed to a data-logger
+//
+// The request is wrapped in a V5 request frame by ToBytes/Frame
+type ModbusRequest struct {
+	Serial   uint32
+	Sequence uint16
+	SlaveID  byte
+	Function ModbusFunction
+	Address  uint16
+	// Number of registers/coils. Derived from Values for write requests
+	Quantity uint16
+	// Values to be written. Any non-zero value turns a coil on
+	Values []uint16
+}
+
+// RTU encodes the Modbus RTU frame (CRC included)
+func (r *ModbusRequest) RTU() []byte {
+	rtu := []byte{r.SlaveID, byte(r.Function)}
+	rtu = binary.BigEndian.AppendUint16(rtu, r.Address)
+	switch r.Function {
+	case FuncWriteSingleCoil:
+		var v uint16
+		if len(r.Values) > 0 && r.Values[0] != 0 {
+			v = 0xff00
+		}
+		rtu = binary.BigEndian.AppendUint16(rtu, v)
+	case FuncWriteSingleRegister:
+		var v uint16
+		if len(r.Values) > 0 {
+			v = r.Values[0]
+		}
+		rtu = binary.BigEndian.AppendUint16(rtu, v)
+	case FuncWriteMultipleCoils:
+		bits := make([]byte, (len(r.Values)+7)/8)
+		for i, v := range r.Values {
+			if v != 0 {
+				bits[i/8] |= 1 << (i % 8)
+			}
+		}
+		rtu = binary.BigEndian.AppendUint16(rtu, uint16(len(r.Values)))
+		rtu = append(rtu, byte(len(bits)))
+		rtu = append(rtu, bits...)
+	case FuncWriteMultipleRegisters:
+		rtu = binary.BigEndian.AppendUint16(rtu, uint16(len(r.Values)))
+		rtu = append(rtu, byte(len(r.Values)*2))
+		for _, v := range r.Values {
+			rtu = binary.BigEndian.AppendUint16(rtu, v)
+		}
+	default:
+		rtu = binary.BigEndian.AppendUint16(rtu, r.Quantity)
+	}
+	return AppendCRC(rtu)
+}
+
+// ToBytes encodes the request as a V5 frame
+func (r *ModbusRequest) ToBytes() []byte {
+	return r.builder().Marshal()
+}
+
+// Frame the request as parsed V5 frame
+func (r *ModbusRequest) Frame() *V5Frame {
+	return r.builder().Frame()
+}
+
+func (r *ModbusRequest) builder() *V5Builder {
+	return NewV5Builder(ControlRequest).
+		Serial(r.Serial).
+		Sequence(r.Sequence).
+		Modbus(r.RTU())
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+)
+
+const maxRTULen = 256
+
+// RTUReader splits a byte stream (RTU over TCP, serial line) into Modbus RTU requests
+//
+// There is no length field in RTU, so the frame length is derived from the function code.
+// Frames with bad CRC are skipped byte by byte until a valid frame is found.
+type RTUReader struct {
+	r *bufio.Reader
+}
+
+// NewRTUReader creates an RTUReader on top of r
+func NewRTUReader(r io.Reader) *RTUReader {
+	return &RTUReader{r: bufio.NewReaderSize(r, maxRTULen*2)}
+}
+
+// ReadRequest blocks until a complete request with valid CRC is available
+func (rr *RTUReader) ReadRequest() ([]byte, error) {
+	for {
+		head, err := rr.r.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		n := 8 // slave + function + address + quantity/value + crc
+		switch ModbusFunction(head[1]) {
+		case FuncReadCoils, FuncReadDiscreteInputs, FuncReadHoldingRegisters, FuncReadInputRegisters,
+			FuncWriteSingleCoil, FuncWriteSingleRegister:
+		case FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+			hdr, err := rr.r.Peek(7)
+			if err != nil {
+				return nil, err
+			}
+			n = 9 + int(hdr[6])
+		default:
+			_, _ = rr.r.Discard(1) // unsupported function - resync
+			continue
+		}
+		frame, err := rr.r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		if !CRCValid(frame) {
+			_, _ = rr.r.Discard(1)
+			continue
+		}
+		rtu := make([]byte, n)
+		copy(rtu, frame)
+		_, _ = rr.r.Discard(n)
+		return rtu, nil
+	}
+}
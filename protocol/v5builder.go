@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	// FrameTypeInverter payload frame type used for requests to the inverter
+	FrameTypeInverter byte = 0x02
+
+	// frame type (1) + sensor type (2) + total working time (4) + power on time (4) + offset time (4)
+	requestPrefixLen = 15
+	// frame type (1) + status (1) + total working time (4) + power on time (4) + offset time (4)
+	responsePrefixLen = 14
+)
+
+// V5Builder assembles V5 frames. Length and checksum are calculated on Marshal
+type V5Builder struct {
+	control ControlCode
+	seq     uint16
+	serial  uint32
+	payload []byte
+}
+
+// NewV5Builder creates a builder for a frame with the given control code
+func NewV5Builder(control ControlCode) *V5Builder {
+	return &V5Builder{control: control}
+}
+
+// Sequence sets the frame sequence number
+func (b *V5Builder) Sequence(seq uint16) *V5Builder {
+	b.seq = seq
+	return b
+}
+
+// Serial sets the data-logger serial number
+func (b *V5Builder) Serial(serial uint32) *V5Builder {
+	b.serial = serial
+	return b
+}
+
+// Payload sets the raw frame payload
+func (b *V5Builder) Payload(payload []byte) *V5Builder {
+	b.payload = payload
+	return b
+}
+
+// Modbus sets the payload to a request/response wrapping the given Modbus RTU frame
+//
+// The payload prefix is selected according to the control code of the builder
+func (b *V5Builder) Modbus(rtu []byte) *V5Builder {
+	if b.control.IsResponse() {
+		b.payload = ResponsePayload(rtu)
+	} else {
+		b.payload = RequestPayload(rtu)
+	}
+	return b
+}
+
+// Marshal encodes the frame
+func (b *V5Builder) Marshal() []byte {
+	packet := make([]byte, headerLen+len(b.payload)+2)
+	packet[0] = V5Start
+	binary.LittleEndian.PutUint16(packet[1:3], uint16(len(b.payload)))
+	binary.LittleEndian.PutUint16(packet[3:5], uint16(b.control))
+	binary.LittleEndian.PutUint16(packet[5:7], b.seq)
+	binary.LittleEndian.PutUint32(packet[7:11], b.serial)
+	copy(packet[headerLen:], b.payload)
+	packet[len(packet)-2] = checksum(packet)
+	packet[len(packet)-1] = V5End
+	return packet
+}
+
+// Frame encodes the frame and returns it parsed
+func (b *V5Builder) Frame() *V5Frame {
+	frame, _ := NewV5Frame(b.Marshal())
+	return frame
+}
+
+// RequestPayload wraps a Modbus RTU frame in a V5 request payload
+func RequestPayload(rtu []byte) []byte {
+	payload := make([]byte, requestPrefixLen+len(rtu))
+	payload[0] = FrameTypeInverter
+	copy(payload[requestPrefixLen:], rtu)
+	return payload
+}
+
+// ResponsePayload wraps a Modbus RTU frame in a V5 response payload
+func ResponsePayload(rtu []byte) []byte {
+	payload := make([]byte, responsePrefixLen+len(rtu))
+	payload[0] = FrameTypeInverter
+	payload[1] = 0x01 // status
+	copy(payload[responsePrefixLen:], rtu)
+	return payload
+}
+
+// TimeResponse builds the server reply for a logger initiated frame
+//
+// The reply carries the current time, same as the replies of Solarman cloud
+func TimeResponse(frame *V5Frame, now time.Time) []byte {
+	payload := make([]byte, 10)
+	payload[1] = 0x01 // status
+	binary.LittleEndian.PutUint32(payload[2:6], uint32(now.Unix()))
+	return NewV5Builder(frame.Control().Response()).
+		Sequence(frame.Sequence()).
+		Serial(frame.LoggerSN()).
+		Payload(payload).
+		Marshal()
+}
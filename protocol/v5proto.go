@@ -1,21 +1,96 @@
 package protocol
 
 /*
-SolarmanV5 JE Protocol
+SolarmanV5 protocol
 
-Just enough (JE) version needed for packet routing.
+Frame layout (all multibyte values are little-endian):
+
+	Start      1 byte   0xa5
+	Length     2 bytes  payload length
+	Control    2 bytes  control code (e.g. 0x4510 - request, 0x1510 - response)
+	Sequence   2 bytes  sequence number
+	Serial     4 bytes  data-logger serial number
+	Payload    N bytes
+	Checksum   1 byte   sum of all bytes between Start and Checksum
+	End        1 byte   0x15
 */
 import (
 	"encoding/binary"
-	"errors"
+	"fmt"
 )
 
 const (
 	V5Start     byte = 0xa5
 	V5End       byte = 0x15
 	minFrameLen int  = 13
+	headerLen   int  = 11
+)
+
+// ControlCode V5 frame control code
+type ControlCode uint16
+
+const (
+	ControlHandshake ControlCode = 0x4110
+	ControlData      ControlCode = 0x4210
+	ControlInfo      ControlCode = 0x4310
+	ControlRequest   ControlCode = 0x4510
+	ControlHeartbeat ControlCode = 0x4710
+	ControlReport    ControlCode = 0x4810
+
+	ControlHandshakeResponse ControlCode = 0x1110
+	ControlDataResponse      ControlCode = 0x1210
+	ControlInfoResponse      ControlCode = 0x1310
+	ControlResponse          ControlCode = 0x1510
+	ControlHeartbeatResponse ControlCode = 0x1710
+	ControlReportResponse    ControlCode = 0x1810
+
+	responseOffset ControlCode = 0x3000
 )
 
+// IsResponse reports whether the control code belongs to a response frame (0x1xxx)
+func (c ControlCode) IsResponse() bool {
+	return c&0xf000 == 0x1000
+}
+
+// Response returns the control code of the response for a request/logger initiated frame
+func (c ControlCode) Response() ControlCode {
+	if c.IsResponse() {
+		return c
+	}
+	return c - responseOffset
+}
+
+func (c ControlCode) String() string {
+	switch c {
+	case ControlHandshake:
+		return "Handshake"
+	case ControlData:
+		return "Data"
+	case ControlInfo:
+		return "Info"
+	case ControlRequest:
+		return "Request"
+	case ControlHeartbeat:
+		return "Heartbeat"
+	case ControlReport:
+		return "Report"
+	case ControlHandshakeResponse:
+		return "HandshakeResponse"
+	case ControlDataResponse:
+		return "DataResponse"
+	case ControlInfoResponse:
+		return "InfoResponse"
+	case ControlResponse:
+		return "Response"
+	case ControlHeartbeatResponse:
+		return "HeartbeatResponse"
+	case ControlReportResponse:
+		return "ReportResponse"
+	default:
+		return fmt.Sprintf("Unknown(0x%04x)", uint16(c))
+	}
+}
+
 type V5Frame struct {
 	head      byte
 	length    [2]byte
@@ -27,19 +102,30 @@ type V5Frame struct {
 	packet    []byte
 }
 
+// NewV5Frame parses a single V5 frame. The data is copied and can be reused by the caller
+//
+// The returned error wraps one of ErrShortFrame, ErrFrameStart or ErrFrameEnd
 func NewV5Frame(data []byte) (*V5Frame, error) {
-	if len(data) < minFrameLen || data[0] != V5Start || data[len(data)-1] != V5End {
-		return nil, errors.New("invalid frame")
+	if len(data) < minFrameLen {
+		return nil, fmt.Errorf("%w: [%d bytes]", ErrShortFrame, len(data))
 	}
+	if data[0] != V5Start {
+		return nil, fmt.Errorf("%w: 0x%02x", ErrFrameStart, data[0])
+	}
+	if data[len(data)-1] != V5End {
+		return nil, fmt.Errorf("%w: 0x%02x", ErrFrameEnd, data[len(data)-1])
+	}
+	packet := make([]byte, len(data))
+	copy(packet, data)
 	frame := &V5Frame{
-		head:      data[0],
-		length:    [2]byte{data[1], data[2]},
-		frameType: [2]byte{data[3], data[4]},
-		seqNo:     [2]byte{data[5], data[6]},
-		serial:    [4]byte{data[7], data[8], data[9], data[10]},
-		checksum:  data[len(data)-2],
-		tail:      data[len(data)-1],
-		packet:    data,
+		head:      packet[0],
+		length:    [2]byte{packet[1], packet[2]},
+		frameType: [2]byte{packet[3], packet[4]},
+		seqNo:     [2]byte{packet[5], packet[6]},
+		serial:    [4]byte{packet[7], packet[8], packet[9], packet[10]},
+		checksum:  packet[len(packet)-2],
+		tail:      packet[len(packet)-1],
+		packet:    packet,
 	}
 
 	return frame, nil
@@ -52,11 +138,7 @@ func (f *V5Frame) ChecksumOK() bool {
 
 // CalculatedChecksum V5 frame checksum
 func (f *V5Frame) CalculatedChecksum() byte {
-	var checksum byte
-	for i := 1; i < len(f.packet)-2; i++ {
-		checksum += f.packet[i] & 0xff
-	}
-	return checksum
+	return checksum(f.packet)
 }
 
 func (f *V5Frame) LoggerSN() uint32 {
@@ -70,3 +152,99 @@ func (f *V5Frame) PayloadLen() uint16 {
 func (f *V5Frame) Length() int {
 	return len(f.packet)
 }
+
+// Control frame control code
+func (f *V5Frame) Control() ControlCode {
+	return ControlCode(binary.LittleEndian.Uint16(f.frameType[:]))
+}
+
+// Sequence frame sequence number
+func (f *V5Frame) Sequence() uint16 {
+	return binary.LittleEndian.Uint16(f.seqNo[:])
+}
+
+// RequestSequence the client part of the sequence number (low byte)
+//
+// Data-loggers echo it back in the response while the high byte is replaced with their own counter
+func (f *V5Frame) RequestSequence() byte {
+	return f.seqNo[0]
+}
+
+// SetRequestSequence replaces the client part of the sequence number
+//
+// The checksum is adjusted with the difference, so a frame with valid checksum stays valid
+func (f *V5Frame) SetRequestSequence(seq byte) {
+	f.checksum += seq - f.seqNo[0]
+	f.seqNo[0] = seq
+	f.packet[5] = seq
+	f.packet[len(f.packet)-2] = f.checksum
+}
+
+// SetLoggerSN replaces the data-logger serial number
+//
+// The checksum is adjusted with the difference, same as in SetRequestSequence
+func (f *V5Frame) SetLoggerSN(serial uint32) {
+	for i := range f.serial {
+		f.checksum -= f.serial[i]
+	}
+	binary.LittleEndian.PutUint32(f.serial[:], serial)
+	for i := range f.serial {
+		f.checksum += f.serial[i]
+	}
+	copy(f.packet[7:11], f.serial[:])
+	f.packet[len(f.packet)-2] = f.checksum
+}
+
+// Checksum the checksum carried by the frame
+func (f *V5Frame) Checksum() byte {
+	return f.checksum
+}
+
+// Payload everything between the header and the checksum
+func (f *V5Frame) Payload() []byte {
+	return f.packet[headerLen : len(f.packet)-2]
+}
+
+// Bytes the raw frame
+func (f *V5Frame) Bytes() []byte {
+	return f.packet
+}
+
+// IsLoggerInitiated reports whether the frame is sent by the data-logger on its own
+// (handshake, data, info, heartbeat, report) and expects a reply from the server
+func (f *V5Frame) IsLoggerInitiated() bool {
+	switch f.Control() {
+	case ControlHandshake, ControlData, ControlInfo, ControlHeartbeat, ControlReport:
+		return true
+	}
+	return false
+}
+
+// ModbusFrame returns the Modbus RTU frame embedded in a request/response frame
+//
+// nil is returned for other frame types or when the payload is too short
+func (f *V5Frame) ModbusFrame() []byte {
+	var offset int
+	switch f.Control() {
+	case ControlRequest:
+		offset = requestPrefixLen
+	case ControlResponse:
+		offset = responsePrefixLen
+	default:
+		return nil
+	}
+	payload := f.Payload()
+	if len(payload) <= offset {
+		return nil
+	}
+	return payload[offset:]
+}
+
+// checksum sum of all bytes between the start byte and the checksum
+func checksum(packet []byte) byte {
+	var sum byte
+	for i := 1; i < len(packet)-2; i++ {
+		sum += packet[i] & 0xff
+	}
+	return sum
+}
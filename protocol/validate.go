@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Validate strict frame verification
+//
+// Checks the length field, the trailer position, the frame checksum and the CRC of the
+// embedded Modbus frame (request/response frames only). The returned error wraps one
+// of ErrLength, ErrTrailer, ErrChecksum or ErrModbusCRC.
+func (f *V5Frame) Validate() error {
+	expected := headerLen + int(f.PayloadLen()) + 2
+	if expected <= len(f.packet) && f.packet[expected-1] != V5End {
+		return fmt.Errorf("%w: no 0x%02x at offset [%d]", ErrTrailer, V5End, expected-1)
+	}
+	if expected != len(f.packet) {
+		return fmt.Errorf("%w: payload length [%d] frame length [%d]", ErrLength, f.PayloadLen(), len(f.packet))
+	}
+	if calculated := f.CalculatedChecksum(); calculated != f.checksum {
+		return fmt.Errorf("%w: got 0x%02x calculated 0x%02x", ErrChecksum, f.checksum, calculated)
+	}
+	rtu := f.ModbusFrame()
+	if len(rtu) >= minModbusLen {
+		crc := binary.LittleEndian.Uint16(rtu[len(rtu)-2:])
+		if calculated := CRC16(rtu[:len(rtu)-2]); calculated != crc {
+			return fmt.Errorf("%w: got 0x%04x calculated 0x%04x", ErrModbusCRC, crc, calculated)
+		}
+	}
+	return nil
+}
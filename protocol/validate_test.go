@@ -0,0 +1,40 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() []byte {
+		return NewV5Builder(ControlRequest).Serial(2712345678).Sequence(0x0101).
+			Modbus(AppendCRC([]byte{0x01, 0x03, 0x00, 0x0a, 0x00, 0x01})).Marshal()
+	}
+	for _, tc := range []struct {
+		name    string
+		corrupt func(frame []byte) []byte
+		want    error
+	}{
+		{"valid", func(f []byte) []byte { return f }, nil},
+		{"valid without modbus", func([]byte) []byte {
+			return NewV5Builder(ControlHeartbeat).Serial(2712345678).Payload([]byte{0x00}).Marshal()
+		}, nil},
+		{"bad checksum", func(f []byte) []byte { f[len(f)-2]++; return f }, ErrChecksum},
+		{"length field too long", func(f []byte) []byte { f[1]++; return f }, ErrLength},
+		{"length field too short", func(f []byte) []byte { f[1]--; return f }, ErrTrailer},
+		{"bad modbus crc", func([]byte) []byte {
+			return NewV5Builder(ControlRequest).Serial(2712345678).
+				Modbus([]byte{0x01, 0x03, 0x00, 0x0a, 0x00, 0x01, 0x00, 0x00}).Marshal()
+		}, ErrModbusCRC},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			frame, err := NewV5Frame(tc.corrupt(valid()))
+			if err != nil {
+				t.Fatalf("frame: %v", err)
+			}
+			if err = frame.Validate(); !errors.Is(err, tc.want) {
+				t.Fatalf("got %v want %v", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package proxytest
+
+import (
+	"net"
+	"time"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// FakeClient V5 client (like pysolarmanv5) connected to the proxy clients port
+type FakeClient struct {
+	Serial uint32
+	conn   net.Conn
+	reader *protocol.FrameReader
+}
+
+// DialClient connects a fake client using the given logger serial
+func DialClient(addr string, serial uint32) (*FakeClient, error) {
+	return dialClient("tcp", addr, serial)
+}
+
+// DialUDPClient creates a fake client sending its requests as UDP datagrams
+func DialUDPClient(addr string, serial uint32) (*FakeClient, error) {
+	return dialClient("udp", addr, serial)
+}
+
+// DialUnixClient connects a fake client to a unix domain socket
+func DialUnixClient(path string, serial uint32) (*FakeClient, error) {
+	return dialClient("unix", path, serial)
+}
+
+func dialClient(network, addr string, serial uint32) (*FakeClient, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &FakeClient{
+		Serial: serial,
+		conn:   conn,
+		reader: protocol.NewFrameReader(conn),
+	}, nil
+}
+
+// ReadHolding sends a holding registers read request with the given sequence number
+func (c *FakeClient) ReadHolding(seq uint16, address, quantity uint16) error {
+	req := &protocol.ModbusRequest{
+		Serial:   c.Serial,
+		Sequence: seq,
+		SlaveID:  1,
+		Function: protocol.FuncReadHoldingRegisters,
+		Address:  address,
+		Quantity: quantity,
+	}
+	return c.Write(req.ToBytes())
+}
+
+// Write sends raw data to the proxy
+func (c *FakeClient) Write(data []byte) error {
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// Next waits for the next frame from the proxy
+func (c *FakeClient) Next(timeout time.Duration) (*protocol.V5Frame, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	return c.reader.ReadFrame()
+}
+
+// Close disconnects the client
+func (c *FakeClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,157 @@
+package proxytest
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// Handler produces the response PDU for a Modbus request. nil means no response
+type Handler func(req *protocol.ModbusFrame) []byte
+
+// RegisterEcho default handler. Read requests are answered with the register addresses as values
+func RegisterEcho(req *protocol.ModbusFrame) []byte {
+	if req.IsException() || len(req.Bytes()) < 6 {
+		return protocol.ExceptionPDU(req.Function, protocol.ExIllegalFunction)
+	}
+	if !req.Function.IsRead() {
+		return append([]byte{}, req.Bytes()[1:6]...)
+	}
+	pdu := []byte{byte(req.Function), byte(req.Quantity * 2)}
+	for i := uint16(0); i < req.Quantity; i++ {
+		pdu = binary.BigEndian.AppendUint16(pdu, req.Address+i)
+	}
+	return pdu
+}
+
+// FakeLogger data-logger connected to the proxy
+type FakeLogger struct {
+	Serial uint32
+	conn   net.Conn
+
+	lock    sync.Mutex
+	handler Handler
+	seq     byte
+	frames  chan *protocol.V5Frame
+	done    chan struct{}
+}
+
+// DialLogger connects a fake data-logger with the given serial to the proxy loggers port
+//
+// Requests are answered by RegisterEcho until SetHandler is called
+func DialLogger(addr string, serial uint32) (*FakeLogger, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newFakeLogger(conn, serial), nil
+}
+
+// ListenLogger waits for the proxy to connect to a fake data-logger in TCP-server mode
+//
+// Requests are answered by RegisterEcho until SetHandler is called
+func ListenLogger(ln net.Listener, serial uint32) (*FakeLogger, error) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newFakeLogger(conn, serial), nil
+}
+
+func newFakeLogger(conn net.Conn, serial uint32) *FakeLogger {
+	l := &FakeLogger{
+		Serial:  serial,
+		conn:    conn,
+		handler: RegisterEcho,
+		frames:  make(chan *protocol.V5Frame, 64),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// SetHandler replaces the request handler
+func (l *FakeLogger) SetHandler(h Handler) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.handler = h
+}
+
+// Frames every frame received from the proxy (the serial probe included)
+func (l *FakeLogger) Frames() <-chan *protocol.V5Frame {
+	return l.frames
+}
+
+// Next waits for the next frame received from the proxy
+func (l *FakeLogger) Next(timeout time.Duration) (*protocol.V5Frame, bool) {
+	select {
+	case f := <-l.frames:
+		return f, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// Send writes a frame built by the caller (e.g. unsolicited data) to the proxy
+func (l *FakeLogger) Send(control protocol.ControlCode, payload []byte) error {
+	_, err := l.conn.Write(l.builder(control, 0).Payload(payload).Marshal())
+	return err
+}
+
+// Write sends raw data to the proxy (e.g. a corrupted frame)
+func (l *FakeLogger) Write(data []byte) error {
+	_, err := l.conn.Write(data)
+	return err
+}
+
+// Close disconnects the logger
+func (l *FakeLogger) Close() error {
+	err := l.conn.Close()
+	<-l.done
+	return err
+}
+
+func (l *FakeLogger) run() {
+	defer close(l.done)
+	reader := protocol.NewFrameReader(l.conn)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			return
+		}
+		select {
+		case l.frames <- frame:
+		default: // nobody is watching
+		}
+		if frame.Control() != protocol.ControlRequest {
+			continue
+		}
+		req, err := frame.Modbus()
+		if err != nil {
+			continue
+		}
+		l.lock.Lock()
+		pdu := l.handler(req)
+		l.lock.Unlock()
+		if pdu == nil {
+			continue
+		}
+		resp := l.builder(protocol.ControlResponse, frame.RequestSequence()).
+			Modbus(protocol.PDUToRTU(req.SlaveID, pdu)).
+			Marshal()
+		if _, err = l.conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (l *FakeLogger) builder(control protocol.ControlCode, reqSeq byte) *protocol.V5Builder {
+	l.lock.Lock()
+	l.seq++
+	seq := uint16(reqSeq) | uint16(l.seq)<<8
+	l.lock.Unlock()
+	return protocol.NewV5Builder(control).Serial(l.Serial).Sequence(seq)
+}
@@ -0,0 +1,115 @@
+// Package proxytest provides utilities for in-process testing of the proxy:
+// a V5ProxyServer on ephemeral ports and fake data-logger/client endpoints.
+package proxytest
+
+import (
+	"net"
+	"time"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	"github.com/githubDante/go-solarman-proxy/server"
+)
+
+// Server V5ProxyServer listening on ephemeral loopback ports
+type Server struct {
+	*server.V5ProxyServer
+}
+
+// Options proxy settings applied before the server is started
+type Options struct {
+	Buffering       bool
+	Strict          bool
+	JanitorInterval time.Duration
+	// Data-loggers in TCP-server mode dialed by the proxy (see ListenLogger)
+	Dial []server.LoggerDial
+	// Cloud tee (see FakeUpstream)
+	Upstream *client.UpstreamRoute
+	// UDP clients listener on an ephemeral port (see DialUDPClient)
+	UDP bool
+	// Unix domain socket clients listener (see DialUnixClient)
+	Unix *server.UnixListener
+	// Handling of clients with unknown serial, DefaultLogger is used by server.UnknownDefault
+	UnknownSerials server.UnknownSerialMode
+	DefaultLogger  uint32
+	// Modbus TCP/RTU frontends, an empty Addr is an ephemeral loopback port (see ModbusAddrs)
+	Modbus []server.ModbusListener
+	// Directory for the pseudo-terminal links (disabled when empty, Linux only)
+	PtyDir string
+	// Data API on an ephemeral loopback port (see DataAPIAddr), AliasEditing adds the alias
+	// write endpoints
+	DataAPI      bool
+	AliasEditing bool
+}
+
+// NewServer starts a proxy with default options
+func NewServer() (*Server, error) {
+	return NewServerWithOptions(Options{})
+}
+
+// NewServerWithOptions starts a proxy on ephemeral loopback ports
+func NewServerWithOptions(opts Options) (*Server, error) {
+	loggersL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	clientsL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		loggersL.Close()
+		return nil, err
+	}
+	proxy := server.NewProxy("127.0.0.1", 0)
+	if opts.Strict {
+		proxy.EnableStrict()
+	}
+	if opts.JanitorInterval > 0 {
+		proxy.JanitorInterval = opts.JanitorInterval
+	}
+	if opts.Upstream != nil {
+		proxy.EnableUpstream(*opts.Upstream)
+	}
+	if opts.UDP {
+		proxy.EnableUDP("127.0.0.1:0")
+	}
+	if opts.DataAPI {
+		proxy.EnableDataAPI("127.0.0.1:0")
+	}
+	if opts.AliasEditing {
+		proxy.EnableAliasEditing()
+	}
+	if opts.UnknownSerials != server.UnknownBroadcast {
+		proxy.RouteUnknownSerials(opts.UnknownSerials, opts.DefaultLogger)
+	}
+	if opts.Unix != nil {
+		proxy.EnableUnix(*opts.Unix)
+	}
+	for _, mb := range opts.Modbus {
+		if mb.Addr == "" {
+			mb.Addr = "127.0.0.1:0"
+		}
+		proxy.AddModbusTCP(mb)
+	}
+	if opts.PtyDir != "" {
+		proxy.EnablePty(opts.PtyDir)
+	}
+	for _, d := range opts.Dial {
+		proxy.AddLoggerDial(d)
+	}
+	if err = proxy.ServeListeners(loggersL, []net.Listener{clientsL}, false, opts.Buffering); err != nil {
+		loggersL.Close()
+		clientsL.Close()
+		return nil, err
+	}
+	return &Server{V5ProxyServer: proxy}, nil
+}
+
+// WaitFor polls cond until it returns true or the timeout expires
+func WaitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
@@ -0,0 +1,95 @@
+package proxytest
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// UpstreamTime time carried by the replies of FakeUpstream
+var UpstreamTime = time.Unix(1700000000, 0)
+
+// FakeUpstream stand-in for the Solarman cloud server
+//
+// Every received frame is recorded and the logger initiated ones are answered
+// with a time response carrying UpstreamTime
+type FakeUpstream struct {
+	ln     net.Listener
+	frames chan *protocol.V5Frame
+
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+// NewUpstream starts a fake cloud server on an ephemeral loopback port
+func NewUpstream() (*FakeUpstream, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	u := &FakeUpstream{ln: ln, frames: make(chan *protocol.V5Frame, 64)}
+	go u.accept()
+	return u, nil
+}
+
+// Addr listen address of the upstream
+func (u *FakeUpstream) Addr() string {
+	return u.ln.Addr().String()
+}
+
+// Next waits for the next frame forwarded by the proxy
+func (u *FakeUpstream) Next(timeout time.Duration) (*protocol.V5Frame, bool) {
+	select {
+	case f := <-u.frames:
+		return f, true
+	case <-time.After(timeout):
+		return nil, false
+	}
+}
+
+// Close stops the listener and drops the proxy connections
+func (u *FakeUpstream) Close() error {
+	err := u.ln.Close()
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for _, conn := range u.conns {
+		_ = conn.Close()
+	}
+	return err
+}
+
+func (u *FakeUpstream) accept() {
+	for {
+		conn, err := u.ln.Accept()
+		if err != nil {
+			return
+		}
+		u.lock.Lock()
+		u.conns = append(u.conns, conn)
+		u.lock.Unlock()
+		go u.serve(conn)
+	}
+}
+
+func (u *FakeUpstream) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := protocol.NewFrameReader(conn)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			return
+		}
+		select {
+		case u.frames <- frame:
+		default: // nobody is watching
+		}
+		if !frame.IsLoggerInitiated() {
+			continue
+		}
+		if _, err = conn.Write(protocol.TimeResponse(frame, UpstreamTime)); err != nil {
+			return
+		}
+	}
+}
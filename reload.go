@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/server"
+)
+
+// reloadable top-level config keys applied on SIGHUP. Everything else needs a restart
+var reloadable = map[string]bool{"log": true, "buffered": true, "timeouts": true, "aliases": true}
+
+// watchReload re-reads the configuration on SIGHUP and applies the settings which don't need
+// new listeners. The logger and client sessions are kept
+//
+// running holds the applied settings, the changes of the other settings are reported on every reload
+func watchReload(proxy *server.V5ProxyServer, running *Config, flags *cmdFlags, args []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		log.LogInfof("[Proxy] SIGHUP received. Reloading configuration\n")
+		if err := reload(proxy, running, flags, args); err != nil {
+			log.LogErrorf("[Proxy] reload failed, configuration unchanged: %s\n", err.Error())
+		}
+	}
+}
+
+// reload resolves the configuration again and applies it. On error nothing is changed
+func reload(proxy *server.V5ProxyServer, running *Config, flags *cmdFlags, args []string) error {
+	cfg, err := resolveConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	applyReload(proxy, running, cfg)
+	return nil
+}
+
+// applyReload applies the reloadable settings of cfg and stores them in running
+func applyReload(proxy *server.V5ProxyServer, running, cfg *Config) {
+	if restart := restartRequired(running, cfg); len(restart) > 0 {
+		log.LogWarnf("[Proxy] changed settings not applied, restart required: %s\n", strings.Join(restart, ", "))
+	}
+
+	setLogLevel(cfg.Log)
+	running.Log = cfg.Log
+
+	if cfg.Buffered != running.Buffered {
+		proxy.SetBuffering(cfg.Buffered)
+		running.Buffered = cfg.Buffered
+	}
+
+	client.SetTimeouts(client.Timeouts{Write: cfg.Timeouts.Write, Request: cfg.Timeouts.Request, Identify: cfg.Timeouts.Identify})
+	running.Timeouts.Write, running.Timeouts.Request, running.Timeouts.Identify =
+		cfg.Timeouts.Write, cfg.Timeouts.Request, cfg.Timeouts.Identify
+
+	// the alias table is replaced, runtime changes through the data API are dropped
+	for virtual, physical := range proxy.Aliases() {
+		if target, ok := cfg.Aliases[virtual]; !ok {
+			proxy.RemoveAlias(virtual)
+		} else if target == physical {
+			delete(cfg.Aliases, virtual)
+		}
+	}
+	for virtual, physical := range cfg.Aliases {
+		proxy.SetAlias(virtual, physical)
+	}
+	running.Aliases = proxy.Aliases()
+
+	log.LogInfof("[Proxy] configuration reloaded\n")
+}
+
+// restartRequired the keys of the changed settings which are applied only on start
+func restartRequired(running, cfg *Config) []string {
+	keys := make([]string, 0)
+	old, cur := reflect.ValueOf(running).Elem(), reflect.ValueOf(cfg).Elem()
+	for i := 0; i < old.NumField(); i++ {
+		key, _, _ := strings.Cut(old.Type().Field(i).Tag.Get("yaml"), ",")
+		if !reloadable[key] && !reflect.DeepEqual(old.Field(i).Interface(), cur.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	if running.Timeouts.Janitor != cfg.Timeouts.Janitor {
+		keys = append(keys, "timeouts.janitor")
+	}
+	return keys
+}
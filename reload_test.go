@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+)
+
+func TestReload(t *testing.T) {
+	t.Cleanup(func() {
+		client.SetTimeouts(client.DefaultTimeouts)
+		setLogLevel("info")
+	})
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	write := func(text string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var flags cmdFlags
+	flags.register(fs)
+	if err := fs.Parse([]string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+
+	write("port: 10000\nlog: silent\naliases: {100: 5, 101: 6}\n")
+	running, err := resolveConfig(&flags, nil)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	setLogLevel(running.Log)
+	proxy := newProxy(running)
+	proxy.SetAlias(102, 7) // runtime change through the data API
+
+	write("port: 10000\nlog: silent\naliases: {100: 5, 101: 6\n")
+	if err = reload(proxy, running, &flags, nil); err == nil {
+		t.Fatalf("invalid file reloaded")
+	}
+	want := map[uint32]uint32{100: 5, 101: 6, 102: 7}
+	if got := proxy.Aliases(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("aliases after failed reload: got %v want %v", got, want)
+	}
+	if running.Timeouts.Request != client.DefaultTimeouts.Request {
+		t.Fatalf("running config changed by failed reload")
+	}
+
+	write("port: 10000\nlog: silent\naliases: {100: 8, 103: 6}\ntimeouts:\n  request: 5s\n")
+	if err = reload(proxy, running, &flags, nil); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	want = map[uint32]uint32{100: 8, 103: 6}
+	if got := proxy.Aliases(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("aliases: got %v want %v", got, want)
+	}
+	if !reflect.DeepEqual(running.Aliases, want) {
+		t.Fatalf("running aliases: got %v want %v", running.Aliases, want)
+	}
+	if got := client.CurrentTimeouts().Request; got != 5*time.Second || running.Timeouts.Request != got {
+		t.Fatalf("request timeout: got %s running %s", got, running.Timeouts.Request)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{"unchanged", func(c *Config) {}, []string{}},
+		{"reloadable only", func(c *Config) {
+			c.Log, c.Buffered = "debug", true
+			c.Timeouts.Request, c.Timeouts.Write, c.Timeouts.Identify = time.Second, time.Second, time.Second
+			c.Aliases = map[uint32]uint32{100: 5}
+		}, []string{}},
+		{"janitor", func(c *Config) { c.Timeouts.Janitor = time.Minute }, []string{"timeouts.janitor"}},
+		{"listeners", func(c *Config) {
+			c.Port = 10001
+			c.Clients = []string{":9000"}
+			c.Modbus.TCP = []string{":502"}
+		}, []string{"port", "clients", "modbus"}},
+		{"routing", func(c *Config) {
+			c.UnknownSerial.Mode = "reject"
+			c.Upstreams = map[uint32]string{5: "a:10000"}
+			c.Timeouts.Janitor = time.Minute
+		}, []string{"unknown_serial", "upstreams", "timeouts.janitor"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			running := defaultConfig()
+			running.Port = 10000
+			cfg := *running
+			tc.change(&cfg)
+			if got := restartRequired(running, &cfg); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+)
+
+// SetAlias - maps a virtual serial used by the clients to the serial of a physical data-logger
+//
+// The serial in the requests is replaced with the physical one and restored in the responses.
+// Clients already using the virtual serial are moved to the new logger. Safe to call at runtime
+func (s *V5ProxyServer) SetAlias(virtual, physical uint32) {
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	s.aliases[virtual] = physical
+	log.LogInfof("[Proxy] serial alias [%d] -> [%d]\n", virtual, physical)
+	s.rebind(virtual)
+}
+
+// RemoveAlias - drops the alias of a virtual serial. Safe to call at runtime
+func (s *V5ProxyServer) RemoveAlias(virtual uint32) {
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	if _, ok := s.aliases[virtual]; !ok {
+		return
+	}
+	delete(s.aliases, virtual)
+	log.LogInfof("[Proxy] serial alias [%d] removed\n", virtual)
+	s.rebind(virtual)
+}
+
+// Aliases - copy of the alias table (virtual serial -> physical serial)
+func (s *V5ProxyServer) Aliases() map[uint32]uint32 {
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	aliases := make(map[uint32]uint32, len(s.aliases))
+	for virtual, physical := range s.aliases {
+		aliases[virtual] = physical
+	}
+	return aliases
+}
+
+// resolveSerial the serial of the data-logger for a serial sent by a client
+//
+// Must be called with mapSync held
+func (s *V5ProxyServer) resolveSerial(serial uint32) uint32 {
+	if physical, ok := s.aliases[serial]; ok {
+		return physical
+	}
+	return serial
+}
+
+// rebind moves the clients using the given serial to the logger it currently resolves to.
+// They stay pending when the logger is not connected
+//
+// Must be called with mapSync held
+func (s *V5ProxyServer) rebind(serial uint32) {
+	moved := make([]*client.ClientSolarman, 0)
+	for _, logger := range s.loggers {
+		for _, cl := range logger.Associated() {
+			if cl.Serial == serial {
+				logger.Remove(cl)
+				cl.AddLogger(nil)
+				moved = append(moved, cl)
+			}
+		}
+	}
+	for _, cl := range s.pending {
+		if cl.Serial == serial {
+			moved = append(moved, cl)
+		}
+	}
+	logger, ok := s.loggers[s.resolveSerial(serial)]
+	for _, cl := range moved {
+		if ok && logger.Running() {
+			logger.Add(cl)
+			cl.AddLogger(logger)
+			delete(s.pending, cl.Id)
+		} else {
+			s.pending[cl.Id] = cl
+		}
+	}
+	if len(moved) > 0 {
+		log.LogInfof("[Proxy] [%d] clients of serial [%d] rebound\n", len(moved), serial)
+	}
+}
+
+// EnableAliasEditing - adds the alias write endpoints (PUT/DELETE /aliases/{virtual}) to the data API
+//
+// The data API has no authentication. Everyone reaching it can redirect the clients of a virtual
+// serial to another data-logger
+func (s *V5ProxyServer) EnableAliasEditing() {
+	s.aliasEdit = true
+}
+
+// handleAliases registers the alias table endpoints of the data API (see EnableDataAPI)
+func (s *V5ProxyServer) handleAliases(mux *http.ServeMux) {
+	mux.HandleFunc("GET /aliases", func(w http.ResponseWriter, r *http.Request) {
+		aliases := make(map[string]uint32)
+		for virtual, physical := range s.Aliases() {
+			aliases[strconv.FormatUint(uint64(virtual), 10)] = physical
+		}
+		writeJSON(w, aliases)
+	})
+	if !s.aliasEdit {
+		return
+	}
+	mux.HandleFunc("PUT /aliases/{virtual}", func(w http.ResponseWriter, r *http.Request) {
+		virtual, err := strconv.ParseUint(r.PathValue("virtual"), 10, 32)
+		if err != nil {
+			http.Error(w, "bad serial number", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 32))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		physical, err := strconv.ParseUint(strings.TrimSpace(string(body)), 10, 32)
+		if err != nil || physical == 0 {
+			http.Error(w, "bad physical serial number", http.StatusBadRequest)
+			return
+		}
+		s.SetAlias(uint32(virtual), uint32(physical))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /aliases/{virtual}", func(w http.ResponseWriter, r *http.Request) {
+		virtual, err := strconv.ParseUint(r.PathValue("virtual"), 10, 32)
+		if err != nil {
+			http.Error(w, "bad serial number", http.StatusBadRequest)
+			return
+		}
+		s.RemoveAlias(uint32(virtual))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
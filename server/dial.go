@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	log "github.com/githubDante/go-solarman-proxy/logging"
+)
+
+const (
+	dialTimeout    = 5 * time.Second
+	dialMinBackoff = 1 * time.Second
+	dialMaxBackoff = 2 * time.Minute
+	// a session which lasted longer resets the backoff
+	dialStableSession = 1 * time.Minute
+)
+
+// LoggerDial data-logger in TCP-server mode. The proxy connects to it instead of waiting
+// for an inbound connection
+type LoggerDial struct {
+	Addr string
+	// Serial used by the serial probe (0 - unknown). Most loggers in server mode
+	// ignore requests with a serial different from their own
+	Serial uint32
+}
+
+// AddLoggerDial - adds a data-logger which is dialed by the proxy. Must be called before Serve
+func (s *V5ProxyServer) AddLoggerDial(d LoggerDial) {
+	s.dialL = append(s.dialL, d)
+}
+
+// dialLogger keeps a connection to a data-logger in TCP-server mode
+//
+// The connection is handled exactly like an accepted one. Failed dials and dropped
+// connections are retried with exponential backoff
+func (s *V5ProxyServer) dialLogger(d LoggerDial) {
+	log.LogInfof("[Loggers-Proxy] dialing logger <%s>\n", d.Addr)
+	backoff := dialMinBackoff
+	for !s.closed() {
+		conn, err := net.DialTimeout("tcp", d.Addr, dialTimeout)
+		if err != nil {
+			log.LogErrorf("Logger <%s> dial error: %s. Retrying in %s\n", d.Addr, err.Error(), backoff)
+		} else {
+			log.LogInfof("Connected to logger <%s>\n", d.Addr)
+			cl := s.registerLogger(conn)
+			cl.SetProbeSerial(d.Serial)
+			started := time.Now()
+			cl.Run()
+			if time.Since(started) > dialStableSession {
+				backoff = dialMinBackoff
+			}
+			log.LogWarnf("Logger <%s> disconnected. Reconnecting in %s\n", d.Addr, backoff)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, dialMaxBackoff)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"strconv"
+)
+
+// ClientListener V5 clients TCP listener
+type ClientListener struct {
+	// tcp, tcp4 or tcp6. With tcp the listener is dual-stack when the address
+	// is unspecified (e.g. [::]:8899)
+	Network string
+	Addr    string
+	// Logger serial for clients which do not send it (0 - routed by the serial in the requests).
+	// The clients of the listener are bound to this logger and their requests are rewritten
+	Serial uint32
+}
+
+// serialListener clients listener dedicated to a single logger
+type serialListener struct {
+	net.Listener
+	serial uint32
+}
+
+// AddClientListener - adds a V5 clients listener (repeatable). Must be called before Serve
+//
+// When none is added the clients are accepted on 0.0.0.0:ClientsPort (tcp4)
+func (s *V5ProxyServer) AddClientListener(l ClientListener) {
+	if l.Network == "" {
+		l.Network = "tcp"
+	}
+	s.clientListeners = append(s.clientListeners, l)
+}
+
+// listenNetwork network for the given listen host when not configured explicitly
+//
+// IPv4 addresses keep tcp4, IPv6 addresses use tcp6 and the rest (unspecified IPv6, host names, empty)
+// use tcp i.e. dual-stack
+func listenNetwork(host string) string {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil || ip.Equal(net.IPv6unspecified):
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// listenClients creates the configured clients listeners
+func (s *V5ProxyServer) listenClients() ([]net.Listener, error) {
+	config := s.clientListeners
+	if len(config) == 0 {
+		config = []ClientListener{{Network: "tcp4", Addr: net.JoinHostPort("0.0.0.0", strconv.Itoa(int(s.ClientsPort)))}}
+	}
+	listeners := make([]net.Listener, 0, len(config))
+	for _, c := range config {
+		l, err := net.Listen(c.Network, c.Addr)
+		if err != nil {
+			for _, created := range listeners {
+				created.Close()
+			}
+			return nil, err
+		}
+		if c.Serial != 0 {
+			l = &serialListener{Listener: l, serial: c.Serial}
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+)
+
+// ModbusListener Modbus TCP or RTU over TCP frontend. Requests are wrapped in V5 frames
+// and sent to the data-logger resolved by Route
+type ModbusListener struct {
+	Addr  string
+	Route client.ModbusRoute
+	// RTU over TCP framing (no MBAP header, CRC included). Only Route.Serial is used
+	RTU bool
+
+	listener net.Listener
+}
+
+// AddModbusTCP - adds a Modbus TCP (or RTU over TCP) listener. Must be called before Serve
+func (s *V5ProxyServer) AddModbusTCP(l ModbusListener) {
+	s.modbusL = append(s.modbusL, &l)
+}
+
+// ModbusAddrs - addresses of the Modbus TCP/RTU listeners in the order they were added
+func (s *V5ProxyServer) ModbusAddrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.modbusL))
+	for _, mb := range s.modbusL {
+		if mb.listener != nil {
+			addrs = append(addrs, mb.listener.Addr())
+		}
+	}
+	return addrs
+}
+
+// runningLogger the connected data-logger for a serial sent by a client (nil if not connected)
+func (s *V5ProxyServer) runningLogger(serial uint32) *client.ClientLogger {
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	logger, ok := s.loggers[s.resolveSerial(serial)]
+	if !ok || !logger.Running() {
+		return nil
+	}
+	return logger
+}
+
+// modbusConn Connection manager for Modbus TCP/RTU clients
+func (s *V5ProxyServer) modbusConn(mb *ModbusListener) {
+	kind := "TCP"
+	if mb.RTU {
+		kind = "RTU"
+	}
+	log.LogInfof("[Modbus-Proxy] waiting for Modbus %s connections on <%s>\n", kind, mb.listener.Addr().String())
+	for {
+		conn, err := mb.listener.Accept()
+		if err != nil {
+			if s.closed() {
+				return
+			}
+			log.LogErrorf("Modbus client connection error: %s\n", err.Error())
+			continue
+		}
+		var cl *client.ClientSolarman
+		if mb.RTU {
+			cl = client.NewRTUClient(conn, mb.Route.Serial, s.clientsComm, s.broadcastComm)
+		} else {
+			cl = client.NewModbusTCPClient(conn, mb.Route, s.clientsComm, s.broadcastComm)
+			if mb.Route.Serial == 0 {
+				cl.SetRouter(s.runningLogger)
+			}
+		}
+		log.LogInfof("New Modbus %s client [%s] connected\n", kind, conn.RemoteAddr().String())
+		s.registerClient(cl)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+)
+
+// DefaultPtyDir directory for the pseudo-terminal symlinks
+const DefaultPtyDir = "/run/solarman"
+
+// ptyAddr address of a pseudo-terminal "connection"
+type ptyAddr string
+
+func (a ptyAddr) Network() string { return "pty" }
+func (a ptyAddr) String() string  { return string(a) }
+
+// ptyConn net.Conn on top of the master side of a pseudo-terminal
+type ptyConn struct {
+	*os.File
+	// slave side, kept open so the master does not fail when the serial tool disconnects
+	slave *os.File
+	link  string
+}
+
+func (p *ptyConn) LocalAddr() net.Addr  { return ptyAddr(p.slave.Name()) }
+func (p *ptyConn) RemoteAddr() net.Addr { return ptyAddr(p.link) }
+
+func (p *ptyConn) Close() error {
+	_ = os.Remove(p.link)
+	_ = p.slave.Close()
+	return p.File.Close()
+}
+
+// EnablePty - creates a Modbus RTU pseudo-terminal for every data-logger. The terminal is
+// linked as <dir>/<serial> (Linux only)
+func (s *V5ProxyServer) EnablePty(dir string) {
+	s.ptyDir = dir
+}
+
+// ensurePty creates the pseudo-terminal bridge for the logger if missing
+//
+// The bridge is a RTU client with preset serial, so it is (re)bound to the logger
+// by checkPending whenever the logger connects
+func (s *V5ProxyServer) ensurePty(serial uint32) {
+	if s.ptyDir == "" {
+		return
+	}
+	s.mapSync.Lock()
+	cl, ok := s.ptys[serial]
+	s.mapSync.Unlock()
+	if ok && cl.Running() {
+		return
+	}
+	link := filepath.Join(s.ptyDir, strconv.FormatUint(uint64(serial), 10))
+	conn, err := openPty(link)
+	if err != nil {
+		log.LogErrorf("[Pty] cannot create terminal for logger [%d]: %s\n", serial, err.Error())
+		return
+	}
+	cl = client.NewRTUClient(conn, serial, s.clientsComm, s.broadcastComm)
+	cl.Serial = serial
+	s.mapSync.Lock()
+	s.ptys[serial] = cl
+	s.mapSync.Unlock()
+	log.LogInfof("[Pty] logger [%d] available on <%s> -> <%s>\n", serial, link, conn.LocalAddr().String())
+	s.registerClient(cl)
+}
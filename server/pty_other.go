@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+)
+
+func openPty(link string) (*ptyConn, error) {
+	return nil, errors.New("pseudo-terminals are supported only on linux")
+}
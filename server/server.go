@@ -3,22 +3,30 @@ package server
 import (
 	"encoding/hex"
 	"errors"
-	"fmt"
 	log "github.com/githubDante/go-solarman-proxy/logging"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/githubDante/go-solarman-proxy/client"
+	"github.com/githubDante/go-solarman-proxy/protocol"
 )
 
 type V5ProxyServer struct {
 	Host        string
 	ClientsPort uint16
 	LoggersPort uint16
+	// Network of the loggers listener (tcp, tcp4, tcp6). Selected by Host when empty
+	LoggersNetwork string
+	// Interval of the checks for stopped loggers/clients
+	JanitorInterval time.Duration
 
 	loggersL net.Listener
-	clientsL net.Listener
+	clientsL []net.Listener
+	// Configured clients listeners (see AddClientListener)
+	clientListeners []ClientListener
 
 	// Data-loggers connected to the proxy
 	//  map[ClientLogger.Serial]*client.ClientLogger
@@ -41,6 +49,54 @@ type V5ProxyServer struct {
 
 	blocker sync.Mutex
 	mapSync sync.Mutex
+
+	// Strict frame validation for loggers and clients
+	strict bool
+	// Sequential communication with the loggers (see SetBuffering)
+	buffering atomic.Bool
+
+	// Data uploads receiver
+	dataComm chan *protocol.DataUpload
+	// Last data upload per data-logger
+	//  map[ClientLogger.Serial]*protocol.DataUpload
+	snapshots map[uint32]*protocol.DataUpload
+	snapSync  sync.RWMutex
+	// Listen address of the data snapshots HTTP API (disabled when empty)
+	dataAddr string
+	dataL    net.Listener
+	// Alias write endpoints of the data API (see EnableAliasEditing)
+	aliasEdit bool
+	// Modbus TCP frontends
+	modbusL []*ModbusListener
+	// Data-loggers in TCP-server mode dialed by the proxy
+	dialL []LoggerDial
+	// Cloud tee for the logger initiated frames (disabled when nil)
+	upstream *client.UpstreamRoute
+	// Directory for the pseudo-terminal links (disabled when empty)
+	ptyDir string
+	// Pseudo-terminal bridges
+	//  map[ClientLogger.Serial]*client.ClientSolarman
+	ptys map[uint32]*client.ClientSolarman
+	// Listen address of the UDP clients listener (disabled when empty)
+	udpAddr string
+	udpL    net.PacketConn
+	// UDP client sessions by source address. Guarded by udpSync, the sessions are closed
+	// with mapSync held (Close)
+	udpSessions map[string]*udpConn
+	udpSync     sync.Mutex
+	// Unix domain socket clients listener (disabled when nil)
+	unix *UnixListener
+	// Handling of clients with unknown serial
+	unknownMode   UnknownSerialMode
+	defaultLogger uint32
+	// Serials of all loggers connected since start
+	known map[uint32]struct{}
+	// Virtual serial -> physical logger serial
+	aliases map[uint32]uint32
+
+	// Closed on server shutdown
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 func NewProxy(host string, loggersPort int) *V5ProxyServer {
@@ -49,15 +105,26 @@ func NewProxy(host string, loggersPort int) *V5ProxyServer {
 		ClientsPort: 8899, //  Same as the data-logger TCP server
 		LoggersPort: uint16(loggersPort),
 
+		JanitorInterval: 30 * time.Second,
+		done:            make(chan struct{}),
+
 		loggersComm:   make(chan *client.CommLogger),
 		clientsComm:   make(chan *client.CommSolarman),
 		loggerStopped: make(chan *client.CommLogger),
 		broadcastComm: make(chan []byte),
+		dataComm:      make(chan *protocol.DataUpload),
 
 		loggers:  make(map[uint32]*client.ClientLogger),
 		martians: make(map[uint32]*client.ClientLogger),
 		pending:  make(map[uint32]*client.ClientSolarman),
 
+		snapshots: make(map[uint32]*protocol.DataUpload),
+		ptys:      make(map[uint32]*client.ClientSolarman),
+
+		udpSessions: make(map[string]*udpConn),
+		known:       make(map[uint32]struct{}),
+		aliases:     make(map[uint32]uint32),
+
 		blocker: sync.Mutex{},
 	}
 }
@@ -67,35 +134,223 @@ func (s *V5ProxyServer) Wait() {
 	s.blocker.Lock()
 }
 
+// EnableStrict - activates strict frame validation for all new logger and client connections
+func (s *V5ProxyServer) EnableStrict() {
+	s.strict = true
+}
+
+// SetBuffering - activates or deactivates the sequential communication for all connected
+// and new loggers. Safe to call at runtime
+func (s *V5ProxyServer) SetBuffering(enabled bool) {
+	s.buffering.Store(enabled)
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	for _, l := range s.loggers {
+		l.SetBuffering(enabled)
+	}
+	for _, l := range s.martians {
+		l.SetBuffering(enabled)
+	}
+}
+
+// EnableUpstream - forwards the frames initiated by the data-loggers to a cloud server
+// (see client.UpstreamRoute). Applied to all new logger connections
+func (s *V5ProxyServer) EnableUpstream(route client.UpstreamRoute) {
+	s.upstream = &route
+}
+
 // Serve - Creates listeners and starts the proxy loops
 func (s *V5ProxyServer) Serve(enableBroadcast, loggerBuffering bool) error {
 
-	var err error
-	s.clientsL, err = net.Listen("tcp4", fmt.Sprintf("%s:%d", "0.0.0.0", s.ClientsPort))
+	clientsL, err := s.listenClients()
 	if err != nil {
 		return errors.New("cannot create clients listener: " + err.Error())
 	}
-	s.loggersL, err = net.Listen("tcp4", fmt.Sprintf("%s:%d", s.Host, s.LoggersPort))
+	network := s.LoggersNetwork
+	if network == "" {
+		network = listenNetwork(s.Host)
+	}
+	loggersL, err := net.Listen(network, net.JoinHostPort(s.Host, strconv.Itoa(int(s.LoggersPort))))
 
 	if err != nil {
+		for _, l := range clientsL {
+			l.Close()
+		}
 		return errors.New("cannot create loggers listener: " + err.Error())
 	}
 
-	log.LogInfof("[Proxy] sockets created. ClientPort [%d] - LoggersPort [%d]\n", 8899, s.LoggersPort)
-	go s.loggersConn(loggerBuffering)
+	return s.ServeListeners(loggersL, clientsL, enableBroadcast, loggerBuffering)
+}
+
+// ServeListeners - Starts the proxy loops on already created listeners (e.g. on ephemeral ports in tests)
+func (s *V5ProxyServer) ServeListeners(loggersL net.Listener, clientsL []net.Listener, enableBroadcast, loggerBuffering bool) error {
+	if len(clientsL) == 0 {
+		return errors.New("no clients listener")
+	}
+	s.loggersL = loggersL
+	s.clientsL = clientsL
+	s.buffering.Store(loggerBuffering)
+
+	var err error
+	for _, mb := range s.modbusL {
+		mb.listener, err = net.Listen("tcp", mb.Addr)
+		if err != nil {
+			return errors.New("cannot create modbus listener: " + err.Error())
+		}
+	}
+	if s.unix != nil {
+		if err = s.unix.listen(); err != nil {
+			return errors.New("cannot create unix clients listener: " + err.Error())
+		}
+	}
+	if s.udpAddr != "" {
+		s.udpL, err = net.ListenPacket("udp", s.udpAddr)
+		if err != nil {
+			return errors.New("cannot create UDP clients listener: " + err.Error())
+		}
+	}
+	if s.dataAddr != "" {
+		s.dataL, err = net.Listen("tcp", s.dataAddr)
+		if err != nil {
+			return errors.New("cannot create data API listener: " + err.Error())
+		}
+	}
+
+	for _, l := range s.clientsL {
+		log.LogInfof("[Proxy] clients socket created [%s]\n", l.Addr().String())
+	}
+	log.LogInfof("[Proxy] sockets created. Loggers [%s]\n", s.loggersL.Addr().String())
+	go s.loggersConn()
 	go s.clientsConn()
 	go s.handleBroadcasts()
 	go s.janitor()
 	if enableBroadcast {
 		go s.handleScanBroadcasts()
 	}
+	if s.dataL != nil {
+		go s.serveDataAPI()
+	}
+	for _, mb := range s.modbusL {
+		go s.modbusConn(mb)
+	}
+	if s.udpL != nil {
+		go s.udpClientsConn()
+	}
+	if s.unix != nil {
+		log.LogInfof("[Clients-Proxy] waiting for client connections on <%s>\n", s.unix.Path)
+		go s.acceptClients(s.unix.listener)
+	}
+	for _, d := range s.dialL {
+		go s.dialLogger(d)
+	}
 
 	s.blocker.Lock()
 	return nil
 }
 
+// Close - stops the listeners and the proxy loops. Connected loggers and clients are disconnected
+func (s *V5ProxyServer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		_ = s.loggersL.Close()
+		for _, l := range s.clientsL {
+			_ = l.Close()
+		}
+		for _, mb := range s.modbusL {
+			_ = mb.listener.Close()
+		}
+		if s.udpL != nil {
+			_ = s.udpL.Close()
+		}
+		if s.dataL != nil {
+			_ = s.dataL.Close()
+		}
+		if s.unix != nil && s.unix.listener != nil {
+			_ = s.unix.listener.Close()
+		}
+
+		s.mapSync.Lock()
+		defer s.mapSync.Unlock()
+		for _, logger := range s.loggers {
+			logger.Stop()
+		}
+		for _, logger := range s.martians {
+			logger.Stop()
+		}
+		for _, cl := range s.pending {
+			cl.Stop()
+		}
+		for _, cl := range s.ptys {
+			cl.Stop()
+		}
+	})
+}
+
+// LoggersAddr - address of the data-loggers listener
+func (s *V5ProxyServer) LoggersAddr() net.Addr {
+	return s.loggersL.Addr()
+}
+
+// ClientsAddr - address of the first clients listener
+func (s *V5ProxyServer) ClientsAddr() net.Addr {
+	return s.clientsL[0].Addr()
+}
+
+// ClientsAddrs - addresses of all clients listeners
+func (s *V5ProxyServer) ClientsAddrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.clientsL))
+	for _, l := range s.clientsL {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// Counts - number of known loggers, loggers with unknown serial and pending clients
+func (s *V5ProxyServer) Counts() (loggers, martians, pending int) {
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	return len(s.loggers), len(s.martians), len(s.pending)
+}
+
+// LoggerStats - frame counters of a connected data-logger
+func (s *V5ProxyServer) LoggerStats(serial uint32) (client.FrameStats, bool) {
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	logger, ok := s.loggers[serial]
+	if !ok {
+		return client.FrameStats{}, false
+	}
+	return logger.Stats(), true
+}
+
+// ClientStats - frame counters of the connected clients by client id
+func (s *V5ProxyServer) ClientStats() map[uint32]client.FrameStats {
+	s.mapSync.Lock()
+	defer s.mapSync.Unlock()
+	stats := make(map[uint32]client.FrameStats)
+	for _, logger := range s.loggers {
+		for _, cl := range logger.Associated() {
+			stats[cl.Id] = cl.Stats()
+		}
+	}
+	for _, cl := range s.pending {
+		stats[cl.Id] = cl.Stats()
+	}
+	return stats
+}
+
+// closed reports whether the server is shutting down
+func (s *V5ProxyServer) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // loggersConn Connection manager for data logger connections
-func (s *V5ProxyServer) loggersConn(enableBuffering bool) {
+func (s *V5ProxyServer) loggersConn() {
 
 	log.LogInfof("[Loggers-Proxy] waiting for logger connections\n")
 
@@ -103,42 +358,72 @@ func (s *V5ProxyServer) loggersConn(enableBuffering bool) {
 		for {
 			select {
 			case logger := <-s.loggersComm:
+				s.mapSync.Lock()
 				s.loggers[logger.Serial] = logger.Logger
+				s.known[logger.Serial] = struct{}{}
+				s.mapSync.Unlock()
 				log.LogInfof("Logger <%s> provided serial [%d]\n",
 					logger.Logger.Conn.RemoteAddr().String(), logger.Serial)
+				s.ensurePty(logger.Serial)
 				s.checkPending(logger)
 			case logger := <-s.loggerStopped:
 				s.handleLoggerDisconnect(logger)
+			case data := <-s.dataComm:
+				s.storeSnapshot(data)
 			}
 		}
 	}()
 
 	for {
 		conn, err := s.loggersL.Accept()
-		log.LogInfof("New loggger connection from: %s\n", conn.RemoteAddr().String())
 		if err != nil {
+			if s.closed() {
+				return
+			}
 			log.LogErrorf("Logger connection error: %s\n", err.Error())
 			continue
 		}
-		cl := client.NewLoggerClient(conn, s.loggersComm, s.loggerStopped)
-		s.martians[cl.Id] = cl
-		if enableBuffering {
-			cl.EnableBuffering()
-		}
+		log.LogInfof("New loggger connection from: %s\n", conn.RemoteAddr().String())
+		cl := s.registerLogger(conn)
 		go cl.Run()
 	}
 }
 
+// registerLogger wraps a logger connection (accepted or dialed) in a ClientLogger and
+// puts it in the martians structure until its serial number is known
+func (s *V5ProxyServer) registerLogger(conn net.Conn) *client.ClientLogger {
+	cl := client.NewLoggerClient(conn, s.loggersComm, s.loggerStopped)
+	cl.DataReporter = s.dataComm
+	s.mapSync.Lock()
+	s.martians[cl.Id] = cl
+	s.mapSync.Unlock()
+	if s.buffering.Load() {
+		cl.EnableBuffering()
+	}
+	if s.strict {
+		cl.EnableStrict()
+	}
+	if s.upstream != nil {
+		cl.EnableUpstream(*s.upstream)
+	}
+	return cl
+}
+
 // checkPending - check for any clients not associated with a freshly connected data-logger
 //
-// When such client is found bindings between the client and the logger are created
+// When such client is found bindings between the client and the logger are created.
+// The default logger gets the clients with unknown serials (see RouteUnknownSerials) and
+// gives back the clients of the logger
 func (s *V5ProxyServer) checkPending(logger *client.CommLogger) {
 	s.mapSync.Lock()
 	defer s.mapSync.Unlock()
 
 	assigned := make([]uint32, 0)
 	for _, cl := range s.pending {
-		if cl.Serial == logger.Serial {
+		serial := s.resolveSerial(cl.Serial)
+		_, seen := s.known[serial]
+		unknown := cl.Identified() && !cl.Pinned() && !seen
+		if serial == logger.Serial || (s.isDefaultLogger(logger.Serial) && unknown) {
 			s.loggers[logger.Serial].Add(cl)
 			cl.AddLogger(logger.Logger)
 			assigned = append(assigned, cl.Id)
@@ -147,26 +432,53 @@ func (s *V5ProxyServer) checkPending(logger *client.CommLogger) {
 	for _, as := range assigned {
 		delete(s.pending, as)
 	}
+	s.reclaimFromDefault(logger.Logger)
 }
 
 func (s *V5ProxyServer) clientsConn() {
 
 	log.LogInfof("[Clients-Proxy] waiting for client connections\n")
 	go s.manageClients()
+	for _, l := range s.clientsL[1:] {
+		go s.acceptClients(l)
+	}
+	s.acceptClients(s.clientsL[0])
+}
+
+// acceptClients accepts V5 clients from a stream listener (TCP or unix socket)
+//
+// Clients of a listener dedicated to a logger are bound to it regardless of the serial they send
+func (s *V5ProxyServer) acceptClients(l net.Listener) {
 	for {
-		conn, err := s.clientsL.Accept()
+		conn, err := l.Accept()
 		if err != nil {
+			if s.closed() {
+				return
+			}
 			log.LogErrorf("Client connection error: %s\n", err.Error())
 			continue
 		}
-		cl := client.NewSolarmanClient(conn, s.clientsComm, s.broadcastComm)
-		log.LogInfof("New solarman client [%s] connected\n", conn.RemoteAddr().String())
+		var cl *client.ClientSolarman
+		if sl, ok := l.(*serialListener); ok {
+			cl = client.NewFixedSerialClient(conn, sl.serial, s.clientsComm, s.broadcastComm)
+		} else {
+			cl = client.NewSolarmanClient(conn, s.clientsComm, s.broadcastComm)
+		}
+		log.LogInfof("New solarman client [%s] connected on <%s>\n", conn.RemoteAddr().String(), l.Addr().String())
+		s.registerClient(cl)
+	}
+}
 
-		s.mapSync.Lock()
-		s.pending[cl.Id] = cl
-		s.mapSync.Unlock()
-		go cl.Run()
+// registerClient puts a freshly connected client in the pending structure and starts it
+func (s *V5ProxyServer) registerClient(cl *client.ClientSolarman) {
+	if s.strict {
+		cl.EnableStrict()
 	}
+	cl.SetUnroutedPolicy(s.unroutedPolicy())
+	s.mapSync.Lock()
+	s.pending[cl.Id] = cl
+	s.mapSync.Unlock()
+	go cl.Run()
 }
 
 // manageClients Clients will be assigned to the logger (if available)
@@ -176,17 +488,19 @@ func (s *V5ProxyServer) clientsConn() {
 func (s *V5ProxyServer) manageClients() {
 	for {
 		cl := <-s.clientsComm // Serial received from a solarman client
-		logger, ok := s.loggers[cl.Serial]
-		if ok && logger.Running {
-			s.mapSync.Lock()
+		s.mapSync.Lock()
+		logger, ok := s.loggers[s.resolveSerial(cl.Serial)]
+		if ok && logger.Running() {
 			logger.Add(cl.Client)
-			cl.Client.Logger = logger
+			cl.Client.AddLogger(logger)
 			delete(s.pending, cl.Client.Id)
-			s.mapSync.Unlock()
 			log.LogDebugf("<%p> removed from pending.\n", cl.Client)
-		} else {
+		} else if _, seen := s.known[s.resolveSerial(cl.Serial)]; seen {
 			log.LogWarnf("No logger connected for [%d]\n", cl.Serial)
+		} else {
+			s.routeUnknown(cl.Client, cl.Serial)
 		}
+		s.mapSync.Unlock()
 	}
 }
 
@@ -195,21 +509,28 @@ func (s *V5ProxyServer) handleBroadcasts() {
 
 		data := <-s.broadcastComm
 		log.LogInfof("Server - broadcasting: %s\n", hex.EncodeToString(data))
+		s.mapSync.Lock()
 		for _, logger := range s.martians {
 			if logger.Serial == 0 {
 				log.LogInfof("Server - broadcasting to %p\n", logger)
 				logger.Conn.Write(data)
 			}
 		}
+		s.mapSync.Unlock()
 	}
 }
 
 func (s *V5ProxyServer) janitor() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(s.JanitorInterval)
+	defer ticker.Stop()
 	for {
-		<-ticker.C
-		s.checkRunningLoggers()
-		s.checkPendingClients()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.checkRunningLoggers()
+			s.checkPendingClients()
+		}
 	}
 }
 
@@ -223,12 +544,12 @@ func (s *V5ProxyServer) checkRunningLoggers() {
 
 	notRunning := make([]uint32, 0)
 	for _, logger := range s.loggers {
-		if !logger.Running {
+		if !logger.Running() {
 			clients := logger.DumpClients()
 			log.LogDebugf("[Server] Logger <%p> not runnig. Dumped [%d] clients.\n", logger, len(clients))
 			logger.Stop()
 			for _, cl := range clients {
-				cl.Logger = nil
+				cl.AddLogger(nil)
 				s.pending[cl.Id] = cl
 			}
 			notRunning = append(notRunning, logger.Serial)
@@ -239,7 +560,7 @@ func (s *V5ProxyServer) checkRunningLoggers() {
 	}
 	mCleanup := make([]uint32, 0)
 	for _, m := range s.martians {
-		if !m.Running {
+		if !m.Running() {
 			m.Stop()
 			mCleanup = append(mCleanup, m.Id)
 		} else if m.Serial != 0 {
@@ -254,16 +575,27 @@ func (s *V5ProxyServer) checkRunningLoggers() {
 
 // handleLoggerDisconnect transfers the clients currently associated with the data-logger
 // to the pending list and removes the logger association with the proxy
+//
+// When the logger has already reconnected the clients are moved to the new connection
 func (s *V5ProxyServer) handleLoggerDisconnect(logger *client.CommLogger) {
 	s.mapSync.Lock()
 	defer s.mapSync.Unlock()
-	clients := logger.Logger.DumpClients()
-	for _, cl := range clients {
-		s.pending[cl.Id] = cl
+	current, ok := s.loggers[logger.Serial]
+	if ok && current == logger.Logger {
+		delete(s.loggers, logger.Serial)
+	}
+	reconnected := ok && current != logger.Logger && current.Running()
+	for _, cl := range logger.Logger.DumpClients() {
+		if reconnected {
+			current.Add(cl)
+			cl.AddLogger(current)
+		} else {
+			cl.AddLogger(nil)
+			s.pending[cl.Id] = cl
+		}
 	}
-	logger.Logger.Clients = nil
 	logger.Logger.Stop()
-	delete(s.loggers, logger.Serial)
+	delete(s.martians, logger.Logger.Id)
 	log.LogDebugf("[Server] Logger <%p> disconnected. Active loggers [%d]\n", logger, len(s.loggers))
 }
 
@@ -274,7 +606,7 @@ func (s *V5ProxyServer) checkPendingClients() {
 
 	notRunning := make([]uint32, 0)
 	for _, cl := range s.pending {
-		if !cl.Running {
+		if !cl.Running() {
 			notRunning = append(notRunning, cl.Id)
 		}
 	}
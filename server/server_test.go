@@ -0,0 +1,1093 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+	"github.com/githubDante/go-solarman-proxy/proxytest"
+	"github.com/githubDante/go-solarman-proxy/server"
+)
+
+const (
+	serial  uint32 = 2712345678
+	timeout        = 2 * time.Second
+)
+
+func init() {
+	log.EnableSilent()
+}
+
+func newServer(t *testing.T, opts proxytest.Options) *proxytest.Server {
+	t.Helper()
+	srv, err := proxytest.NewServerWithOptions(opts)
+	if err != nil {
+		t.Fatalf("server start: %v", err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dialLogger(t *testing.T, srv *proxytest.Server, sn uint32) *proxytest.FakeLogger {
+	t.Helper()
+	l, err := proxytest.DialLogger(srv.LoggersAddr().String(), sn)
+	if err != nil {
+		t.Fatalf("logger dial: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	// the proxy probes for the serial number on connect
+	if _, ok := l.Next(timeout); !ok {
+		t.Fatalf("no serial probe from the proxy")
+	}
+	if !proxytest.WaitFor(timeout, func() bool { n, _, _ := srv.Counts(); return n > 0 }) {
+		t.Fatalf("logger [%d] not registered", sn)
+	}
+	return l
+}
+
+func dialClient(t *testing.T, srv *proxytest.Server, sn uint32) *proxytest.FakeClient {
+	t.Helper()
+	c, err := proxytest.DialClient(srv.ClientsAddr().String(), sn)
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// readHolding sends a request and waits for the matching response
+func readHolding(t *testing.T, c *proxytest.FakeClient, seq uint16, address uint16) *protocol.V5Frame {
+	t.Helper()
+	if err := c.ReadHolding(seq, address, 1); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+	resp, err := c.Next(timeout)
+	if err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	if resp.RequestSequence() != byte(seq) {
+		t.Fatalf("sequence number: got [%d] want [%d]", resp.RequestSequence(), byte(seq))
+	}
+	m, err := resp.Modbus()
+	if err != nil {
+		t.Fatalf("response decode: %v", err)
+	}
+	if regs := m.Registers(); len(regs) != 1 || regs[0] != address {
+		t.Fatalf("registers: got %v want [%d]", regs, address)
+	}
+	return resp
+}
+
+func TestClientBoundToConnectedLogger(t *testing.T) {
+	srv := newServer(t, proxytest.Options{})
+	dialLogger(t, srv, serial)
+	c := dialClient(t, srv, serial)
+
+	readHolding(t, c, 1, 10)
+	readHolding(t, c, 2, 11)
+
+	if _, _, pending := srv.Counts(); pending != 0 {
+		t.Fatalf("pending clients: got [%d] want [0]", pending)
+	}
+}
+
+func TestPendingClientBoundOnLoggerConnect(t *testing.T) {
+	srv := newServer(t, proxytest.Options{})
+	c := dialClient(t, srv, serial)
+	if err := c.ReadHolding(1, 10, 1); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 1 }) {
+		t.Fatalf("client not pending")
+	}
+
+	dialLogger(t, srv, serial)
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 0 }) {
+		t.Fatalf("client not bound to the logger")
+	}
+	// The first request may be broadcast to the logger before its serial is known
+	if err := c.ReadHolding(2, 20, 1); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+	for {
+		resp, err := c.Next(timeout)
+		if err != nil {
+			t.Fatalf("no response: %v", err)
+		}
+		switch resp.RequestSequence() {
+		case 1:
+			continue
+		case 2:
+			return
+		default:
+			t.Fatalf("sequence number: got [%d] want [2]", resp.RequestSequence())
+		}
+	}
+}
+
+func TestLoggerReconnect(t *testing.T) {
+	srv := newServer(t, proxytest.Options{})
+	l := dialLogger(t, srv, serial)
+	c := dialClient(t, srv, serial)
+	readHolding(t, c, 1, 10)
+
+	l.Close()
+	if !proxytest.WaitFor(timeout, func() bool { n, _, p := srv.Counts(); return n == 0 && p == 1 }) {
+		t.Fatalf("client not moved to pending after logger disconnect")
+	}
+
+	dialLogger(t, srv, serial)
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 0 }) {
+		t.Fatalf("client not bound to the reconnected logger")
+	}
+	readHolding(t, c, 2, 30)
+}
+
+func TestLoggerReconnectBeforeDisconnect(t *testing.T) {
+	srv := newServer(t, proxytest.Options{})
+	old := dialLogger(t, srv, serial)
+	c := dialClient(t, srv, serial)
+	readHolding(t, c, 1, 10)
+
+	// the new connection registers while the old one is still up
+	l, err := proxytest.DialLogger(srv.LoggersAddr().String(), serial)
+	if err != nil {
+		t.Fatalf("logger dial: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if _, ok := l.Next(timeout); !ok {
+		t.Fatalf("no serial probe from the proxy")
+	}
+	time.Sleep(50 * time.Millisecond)
+	readHolding(t, dialClient(t, srv, serial), 1, 20)
+	if f, ok := l.Next(timeout); !ok || f.Control() != protocol.ControlRequest {
+		t.Fatalf("request not sent to the new logger connection")
+	}
+
+	old.Close()
+	seq := uint16(2)
+	if !proxytest.WaitFor(timeout, func() bool {
+		seq++
+		if err := c.ReadHolding(seq, 30, 1); err != nil {
+			t.Fatal(err)
+		}
+		_, err := c.Next(50 * time.Millisecond)
+		return err == nil
+	}) {
+		t.Fatalf("client not moved to the new logger connection")
+	}
+	readHolding(t, c, seq+1, 40)
+	if _, _, pending := srv.Counts(); pending != 0 {
+		t.Fatalf("pending clients: got [%d] want [0]", pending)
+	}
+}
+
+func TestResponsesRoutedToRequester(t *testing.T) {
+	srv := newServer(t, proxytest.Options{})
+	dialLogger(t, srv, serial)
+	c1 := dialClient(t, srv, serial)
+	c2 := dialClient(t, srv, serial)
+	readHolding(t, c1, 1, 1)
+	readHolding(t, c2, 1, 2)
+
+	// same sequence number from both clients
+	for i := uint16(0); i < 5; i++ {
+		if err := c1.ReadHolding(7, 100+i, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := c2.ReadHolding(7, 200+i, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		client *proxytest.FakeClient
+		base   uint16
+	}{{c1, 100}, {c2, 200}} {
+		for i := uint16(0); i < 5; i++ {
+			resp, err := tc.client.Next(timeout)
+			if err != nil {
+				t.Fatalf("no response: %v", err)
+			}
+			m, err := resp.Modbus()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.RequestSequence() != 7 || m.Registers()[0] != tc.base+i {
+				t.Fatalf("got seq [%d] register [%d], want seq [7] register [%d]",
+					resp.RequestSequence(), m.Registers()[0], tc.base+i)
+			}
+		}
+	}
+}
+
+func TestStrictDropsCorruptedFrames(t *testing.T) {
+	srv := newServer(t, proxytest.Options{Strict: true})
+	l := dialLogger(t, srv, serial)
+	c := dialClient(t, srv, serial)
+	readHolding(t, c, 1, 10)
+
+	badCRC := func(control protocol.ControlCode, seq uint16, rtu ...byte) []byte {
+		return protocol.NewV5Builder(control).Serial(serial).Sequence(seq).
+			Modbus(append(rtu, 0x00, 0x00)).Marshal()
+	}
+	badChecksum := func(control protocol.ControlCode, seq uint16, rtu ...byte) []byte {
+		frame := protocol.NewV5Builder(control).Serial(serial).Sequence(seq).
+			Modbus(protocol.AppendCRC(rtu)).Marshal()
+		frame[len(frame)-2]++
+		return frame
+	}
+	wantDropped := func(stats client.FrameStats) {
+		t.Helper()
+		want := map[string]uint64{protocol.ErrChecksum.Error(): 1, protocol.ErrModbusCRC.Error(): 1}
+		if stats.Dropped != 2 || len(stats.Reasons) != 2 ||
+			stats.Reasons[protocol.ErrChecksum.Error()] != 1 || stats.Reasons[protocol.ErrModbusCRC.Error()] != 1 {
+			t.Fatalf("stats: got dropped [%d] %v want [2] %v", stats.Dropped, stats.Reasons, want)
+		}
+	}
+
+	// client -> logger, never reach the logger (RegisterEcho answers every request)
+	for _, frame := range [][]byte{
+		badChecksum(protocol.ControlRequest, 2, 0x01, 0x03, 0x00, 0x14, 0x00, 0x01),
+		badCRC(protocol.ControlRequest, 3, 0x01, 0x03, 0x00, 0x14, 0x00, 0x01),
+	} {
+		if err := c.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp, err := c.Next(200 * time.Millisecond); err == nil {
+		t.Fatalf("corrupted request forwarded, got response seq [%d]", resp.RequestSequence())
+	}
+	clients := srv.ClientStats()
+	if len(clients) != 1 {
+		t.Fatalf("client stats: got %d clients want 1", len(clients))
+	}
+	for _, stats := range clients {
+		wantDropped(stats)
+	}
+
+	// logger -> client, the request is answered by the test
+	l.SetHandler(func(*protocol.ModbusFrame) []byte { return nil })
+	for len(l.Frames()) > 0 {
+		<-l.Frames()
+	}
+	if err := c.ReadHolding(4, 20, 1); err != nil {
+		t.Fatal(err)
+	}
+	req, ok := l.Next(timeout)
+	if !ok {
+		t.Fatalf("request not forwarded")
+	}
+	seq := uint16(req.RequestSequence())
+	for _, frame := range [][]byte{
+		badChecksum(protocol.ControlResponse, seq, 0x01, 0x03, 0x02, 0x00, 0x14),
+		badCRC(protocol.ControlResponse, seq, 0x01, 0x03, 0x02, 0x00, 0x14),
+	} {
+		if err := l.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp, err := c.Next(200 * time.Millisecond); err == nil {
+		t.Fatalf("corrupted response forwarded, got seq [%d]", resp.RequestSequence())
+	}
+	stats, ok := srv.LoggerStats(serial)
+	if !ok {
+		t.Fatalf("no logger stats")
+	}
+	wantDropped(stats)
+
+	// the request is still routed when answered with a valid frame
+	valid := protocol.NewV5Builder(protocol.ControlResponse).Serial(serial).Sequence(seq).
+		Modbus(protocol.AppendCRC([]byte{0x01, 0x03, 0x02, 0x00, 0x14})).Marshal()
+	if err := l.Write(valid); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Next(timeout)
+	if err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	if resp.RequestSequence() != 4 {
+		t.Fatalf("sequence number: got [%d] want [4]", resp.RequestSequence())
+	}
+}
+
+func TestJanitorDropsDisconnectedClients(t *testing.T) {
+	srv := newServer(t, proxytest.Options{JanitorInterval: 20 * time.Millisecond})
+	c := dialClient(t, srv, serial)
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 1 }) {
+		t.Fatalf("client not pending")
+	}
+	c.Close()
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 0 }) {
+		t.Fatalf("disconnected client not removed by the janitor")
+	}
+}
+
+func TestLoggerFramesAnsweredByProxy(t *testing.T) {
+	srv := newServer(t, proxytest.Options{})
+	l := dialLogger(t, srv, serial)
+
+	if err := l.Send(protocol.ControlHeartbeat, []byte{0x00}); err != nil {
+		t.Fatal(err)
+	}
+	reply, ok := l.Next(timeout)
+	if !ok {
+		t.Fatalf("no heartbeat reply")
+	}
+	if reply.Control() != protocol.ControlHeartbeatResponse || reply.LoggerSN() != serial {
+		t.Fatalf("reply: got %s [%d]", reply.Control(), reply.LoggerSN())
+	}
+	if err := reply.Validate(); err != nil {
+		t.Fatalf("invalid reply: %v", err)
+	}
+}
+
+func TestDataUploadSnapshot(t *testing.T) {
+	srv := newServer(t, proxytest.Options{DataAPI: true})
+	l := dialLogger(t, srv, serial)
+
+	payload := []byte{0x01, 0x02, 0x00}                       // frame type, sensor type
+	payload = binary.LittleEndian.AppendUint32(payload, 3600) // working time
+	payload = binary.LittleEndian.AppendUint32(payload, 60)   // power on time
+	payload = binary.LittleEndian.AppendUint32(payload, 5)    // offset time
+	payload = append(payload, 0x00, 0x0a, 0x12, 0x34)
+	if err := l.Send(protocol.ControlData, payload); err != nil {
+		t.Fatal(err)
+	}
+	reply, ok := l.Next(timeout)
+	if !ok {
+		t.Fatalf("no data reply")
+	}
+	if reply.Control() != protocol.ControlDataResponse || reply.LoggerSN() != serial {
+		t.Fatalf("reply: got %s [%d]", reply.Control(), reply.LoggerSN())
+	}
+	if err := reply.Validate(); err != nil {
+		t.Fatalf("invalid reply: %v", err)
+	}
+
+	var d *protocol.DataUpload
+	if !proxytest.WaitFor(timeout, func() bool { d, ok = srv.Snapshot(serial); return ok }) {
+		t.Fatalf("no snapshot for [%d]", serial)
+	}
+	if d.Serial != serial || d.FrameType != 0x01 || d.SensorType != 0x0002 ||
+		d.WorkingTime != 3600 || d.PowerOnTime != 60 || d.OffsetTime != 5 {
+		t.Fatalf("snapshot: got %+v", d)
+	}
+	if regs := d.Registers(); len(regs) != 2 || regs[0] != 0x000a || regs[1] != 0x1234 {
+		t.Fatalf("registers: got %04x", regs)
+	}
+	if _, ok = srv.Snapshot(serial + 1); ok {
+		t.Fatalf("snapshot stored for another serial")
+	}
+
+	resp, err := http.Get("http://" + srv.DataAPIAddr().String() + "/data/" + strconv.FormatUint(uint64(serial), 10))
+	if err != nil {
+		t.Fatalf("GET /data: %v", err)
+	}
+	defer resp.Body.Close()
+	var snap struct {
+		Serial    uint32   `json:"serial"`
+		Data      string   `json:"data"`
+		Registers []uint16 `json:"registers"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		t.Fatalf("GET /data: status %s: %v", resp.Status, err)
+	}
+	if snap.Serial != serial || snap.Data != "000a1234" || len(snap.Registers) != 2 || snap.Registers[1] != 0x1234 {
+		t.Fatalf("GET /data: got %+v", snap)
+	}
+	missing, err := http.Get("http://" + srv.DataAPIAddr().String() + "/data/1")
+	if err != nil {
+		t.Fatalf("GET /data: %v", err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Fatalf("GET /data of unknown logger: got %s", missing.Status)
+	}
+}
+
+func TestDialedLogger(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	srv := newServer(t, proxytest.Options{Dial: []server.LoggerDial{{Addr: ln.Addr().String(), Serial: serial}}})
+
+	l, err := proxytest.ListenLogger(ln, serial)
+	if err != nil {
+		t.Fatalf("logger accept: %v", err)
+	}
+	probe, ok := l.Next(timeout)
+	if !ok {
+		t.Fatalf("no serial probe from the proxy")
+	}
+	if probe.LoggerSN() != serial {
+		t.Fatalf("probe serial: got [%d] want [%d]", probe.LoggerSN(), serial)
+	}
+	if !proxytest.WaitFor(timeout, func() bool { n, _, _ := srv.Counts(); return n == 1 }) {
+		t.Fatalf("dialed logger not registered")
+	}
+	c := dialClient(t, srv, serial)
+	readHolding(t, c, 1, 10)
+
+	// the proxy reconnects after the logger drops the connection
+	l.Close()
+	l, err = proxytest.ListenLogger(ln, serial)
+	if err != nil {
+		t.Fatalf("logger accept: %v", err)
+	}
+	defer l.Close()
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 0 }) {
+		t.Fatalf("client not bound to the redialed logger")
+	}
+	readHolding(t, c, 2, 20)
+}
+
+// heartbeat sends a heartbeat from the logger and returns the time carried by the reply
+func heartbeat(t *testing.T, l *proxytest.FakeLogger) time.Time {
+	t.Helper()
+	if err := l.Send(protocol.ControlHeartbeat, []byte{0x00}); err != nil {
+		t.Fatal(err)
+	}
+	reply, ok := l.Next(timeout)
+	if !ok {
+		t.Fatalf("no heartbeat reply")
+	}
+	if reply.Control() != protocol.ControlHeartbeatResponse {
+		t.Fatalf("reply: got %s", reply.Control())
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint32(reply.Payload()[2:6])), 0)
+}
+
+func TestUpstreamTee(t *testing.T) {
+	up, err := proxytest.NewUpstream()
+	if err != nil {
+		t.Fatalf("upstream: %v", err)
+	}
+	defer up.Close()
+	srv := newServer(t, proxytest.Options{Upstream: &client.UpstreamRoute{Default: up.Addr()}})
+	l := dialLogger(t, srv, serial)
+
+	if ts := heartbeat(t, l); !ts.Equal(proxytest.UpstreamTime) {
+		t.Fatalf("reply not from upstream: %s", ts)
+	}
+	frame, ok := up.Next(timeout)
+	if !ok {
+		t.Fatalf("heartbeat not forwarded")
+	}
+	if frame.Control() != protocol.ControlHeartbeat || frame.LoggerSN() != serial {
+		t.Fatalf("forwarded: got %s [%d]", frame.Control(), frame.LoggerSN())
+	}
+
+	// client requests stay local
+	c := dialClient(t, srv, serial)
+	readHolding(t, c, 1, 10)
+	if f, ok := up.Next(100 * time.Millisecond); ok {
+		t.Fatalf("unexpected upstream frame %s", f.Control())
+	}
+}
+
+func TestUpstreamUnreachable(t *testing.T) {
+	up, err := proxytest.NewUpstream()
+	if err != nil {
+		t.Fatalf("upstream: %v", err)
+	}
+	up.Close()
+	srv := newServer(t, proxytest.Options{Upstream: &client.UpstreamRoute{
+		Loggers: map[uint32]string{serial: up.Addr()},
+	}})
+	l := dialLogger(t, srv, serial)
+
+	// answered by the proxy
+	if ts := heartbeat(t, l); ts.Equal(proxytest.UpstreamTime) {
+		t.Fatalf("reply from a closed upstream")
+	}
+}
+
+func TestUDPClient(t *testing.T) {
+	srv := newServer(t, proxytest.Options{UDP: true})
+	dialLogger(t, srv, serial)
+	c, err := proxytest.DialUDPClient(srv.UDPAddr().String(), serial)
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	defer c.Close()
+
+	readHolding(t, c, 1, 10)
+	readHolding(t, c, 2, 11)
+
+	// junk datagrams do not break the session
+	if err := c.Write([]byte{0x01, 0x02, 0x03}); err != nil {
+		t.Fatal(err)
+	}
+	readHolding(t, c, 3, 12)
+}
+
+func TestCloseWithPendingUDPClient(t *testing.T) {
+	srv := newServer(t, proxytest.Options{UDP: true})
+	c, err := proxytest.DialUDPClient(srv.UDPAddr().String(), serial)
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	defer c.Close()
+	if err = c.ReadHolding(1, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 1 }) {
+		t.Fatalf("client not pending")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(timeout):
+		t.Fatalf("Close blocked with a pending UDP client")
+	}
+}
+
+func TestUnixClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.sock")
+	srv := newServer(t, proxytest.Options{Unix: &server.UnixListener{Path: path, Mode: 0o660}})
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("socket: %v", err)
+	}
+	if fi.Mode().Perm() != 0o660 {
+		t.Fatalf("socket mode: got %o want 660", fi.Mode().Perm())
+	}
+
+	dialLogger(t, srv, serial)
+	c, err := proxytest.DialUnixClient(path, serial)
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	defer c.Close()
+	readHolding(t, c, 1, 10)
+}
+
+func TestClientListeners(t *testing.T) {
+	proxy := server.NewProxy("127.0.0.1", 0)
+	proxy.AddClientListener(server.ClientListener{Network: "tcp4", Addr: "127.0.0.1:0"})
+	listeners := 1
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		ln.Close()
+		proxy.AddClientListener(server.ClientListener{Network: "tcp6", Addr: "[::1]:0"})
+		listeners++
+	}
+	if err := proxy.Serve(false, false); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	srv := &proxytest.Server{V5ProxyServer: proxy}
+	t.Cleanup(srv.Close)
+	dialLogger(t, srv, serial)
+
+	addrs := proxy.ClientsAddrs()
+	if len(addrs) != listeners {
+		t.Fatalf("clients listeners: got %d want %d", len(addrs), listeners)
+	}
+	for i, addr := range addrs {
+		c, err := proxytest.DialClient(addr.String(), serial)
+		if err != nil {
+			t.Fatalf("client dial %s: %v", addr, err)
+		}
+		readHolding(t, c, uint16(i+1), 10)
+		c.Close()
+	}
+}
+
+func TestDedicatedClientListener(t *testing.T) {
+	const other uint32 = 2799999999
+	proxy := server.NewProxy("127.0.0.1", 0)
+	proxy.AddClientListener(server.ClientListener{Addr: "127.0.0.1:0"})
+	proxy.AddClientListener(server.ClientListener{Addr: "127.0.0.1:0", Serial: serial})
+	if err := proxy.Serve(false, false); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	srv := &proxytest.Server{V5ProxyServer: proxy}
+	t.Cleanup(srv.Close)
+	// requests routed to the wrong logger are not answered
+	dialLogger(t, srv, other).SetHandler(func(*protocol.ModbusFrame) []byte { return nil })
+	dialLogger(t, srv, serial)
+	if !proxytest.WaitFor(timeout, func() bool { n, _, _ := srv.Counts(); return n == 2 }) {
+		t.Fatalf("loggers not registered")
+	}
+
+	// the client does not know the serial
+	c, err := proxytest.DialClient(proxy.ClientsAddrs()[1].String(), 0)
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	defer c.Close()
+	resp := readHolding(t, c, 1, 10)
+	if resp.LoggerSN() != 0 {
+		t.Fatalf("response serial: got [%d] want [0]", resp.LoggerSN())
+	}
+	if err := resp.Validate(); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+}
+
+func TestUnknownSerialDefaultLogger(t *testing.T) {
+	srv := newServer(t, proxytest.Options{UnknownSerials: server.UnknownDefault, DefaultLogger: serial})
+	l := dialLogger(t, srv, serial)
+
+	for i, sn := range []uint32{0, 1234} {
+		c := dialClient(t, srv, sn)
+		resp := readHolding(t, c, uint16(i+1), 10)
+		if resp.LoggerSN() != sn {
+			t.Fatalf("response serial: got [%d] want [%d]", resp.LoggerSN(), sn)
+		}
+	}
+	// the logger gets requests with its own serial
+	for {
+		f, ok := l.Next(100 * time.Millisecond)
+		if !ok {
+			break
+		}
+		if f.LoggerSN() != serial {
+			t.Fatalf("logger request serial: got [%d] want [%d]", f.LoggerSN(), serial)
+		}
+	}
+}
+
+func TestUnknownSerialLoggerConnectsLater(t *testing.T) {
+	const late uint32 = 2799999999
+	srv := newServer(t, proxytest.Options{UnknownSerials: server.UnknownDefault, DefaultLogger: serial})
+	def := dialLogger(t, srv, serial)
+	c := dialClient(t, srv, late)
+	readHolding(t, c, 1, 10) // answered by the default logger
+
+	dialLogger(t, srv, late)
+	if !proxytest.WaitFor(timeout, func() bool { n, _, _ := srv.Counts(); return n == 2 }) {
+		t.Fatalf("loggers not registered")
+	}
+	time.Sleep(50 * time.Millisecond) // the client is moved right after the registration
+	def.SetHandler(func(*protocol.ModbusFrame) []byte { return nil })
+	if resp := readHolding(t, c, 2, 20); resp.LoggerSN() != late {
+		t.Fatalf("response serial: got [%d] want [%d]", resp.LoggerSN(), late)
+	}
+}
+
+func TestUnknownSerialDedicatedListener(t *testing.T) {
+	const dedicated uint32 = 2799999999
+	proxy := server.NewProxy("127.0.0.1", 0)
+	proxy.AddClientListener(server.ClientListener{Addr: "127.0.0.1:0"})
+	proxy.AddClientListener(server.ClientListener{Addr: "127.0.0.1:0", Serial: dedicated})
+	proxy.RouteUnknownSerials(server.UnknownDefault, serial)
+	if err := proxy.Serve(false, false); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	srv := &proxytest.Server{V5ProxyServer: proxy}
+	t.Cleanup(srv.Close)
+	def := dialLogger(t, srv, serial)
+
+	c, err := proxytest.DialClient(proxy.ClientsAddrs()[1].String(), 0)
+	if err != nil {
+		t.Fatalf("client dial: %v", err)
+	}
+	defer c.Close()
+	if err = c.ReadHolding(1, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := def.Next(200 * time.Millisecond); ok {
+		t.Fatalf("dedicated client routed to the default logger: %s", f.Control())
+	}
+
+	dialLogger(t, srv, dedicated)
+	if !proxytest.WaitFor(timeout, func() bool { n, _, _ := srv.Counts(); return n == 2 }) {
+		t.Fatalf("loggers not registered")
+	}
+	time.Sleep(50 * time.Millisecond) // the pending client is bound right after the registration
+	readHolding(t, c, 2, 20)
+}
+
+func TestUnknownSerialZeroStaysConnected(t *testing.T) {
+	client.SetTimeouts(client.Timeouts{Write: time.Second, Request: time.Second, Identify: 300 * time.Millisecond})
+	t.Cleanup(func() { client.SetTimeouts(client.DefaultTimeouts) })
+	srv := newServer(t, proxytest.Options{UnknownSerials: server.UnknownDefault, DefaultLogger: serial})
+	dialLogger(t, srv, serial)
+	c := dialClient(t, srv, 0)
+
+	readHolding(t, c, 1, 10)
+	time.Sleep(500 * time.Millisecond) // idle longer than the identification timeout
+	readHolding(t, c, 2, 20)
+}
+
+func TestUnknownSerialReject(t *testing.T) {
+	srv := newServer(t, proxytest.Options{UnknownSerials: server.UnknownReject})
+	dialLogger(t, srv, serial)
+	c := dialClient(t, srv, 1234)
+	if err := c.ReadHolding(7, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Next(timeout)
+	if err != nil {
+		t.Fatalf("no error frame: %v", err)
+	}
+	if resp.RequestSequence() != 7 {
+		t.Fatalf("sequence number: got [%d] want [7]", resp.RequestSequence())
+	}
+	m, err := resp.Modbus()
+	if err != nil {
+		t.Fatalf("response decode: %v", err)
+	}
+	if !m.IsException() || m.Exception != protocol.ExGatewayPathFailed {
+		t.Fatalf("response: got %s, want gateway path exception", m)
+	}
+}
+
+func TestUnknownSerialPending(t *testing.T) {
+	srv := newServer(t, proxytest.Options{UnknownSerials: server.UnknownPending})
+	c := dialClient(t, srv, serial)
+	if err := c.ReadHolding(1, 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the proxy drop the request
+	l, err := proxytest.DialLogger(srv.LoggersAddr().String(), serial)
+	if err != nil {
+		t.Fatalf("logger dial: %v", err)
+	}
+	defer l.Close()
+	// the dropped request is not delivered when the logger connects
+	if f, ok := l.Next(timeout); !ok || f.RequestSequence() != 0 {
+		t.Fatalf("expected the serial probe only")
+	}
+	if f, ok := l.Next(100 * time.Millisecond); ok {
+		t.Fatalf("unexpected request [%d] to the logger", f.RequestSequence())
+	}
+	if !proxytest.WaitFor(timeout, func() bool { _, _, p := srv.Counts(); return p == 0 }) {
+		t.Fatalf("client not bound to the logger")
+	}
+	readHolding(t, c, 2, 20)
+}
+
+func TestSerialAlias(t *testing.T) {
+	const (
+		virtual     uint32 = 100
+		replacement uint32 = 2799999999
+	)
+	srv := newServer(t, proxytest.Options{})
+	srv.SetAlias(virtual, serial)
+	old := dialLogger(t, srv, serial)
+	c := dialClient(t, srv, virtual)
+	if resp := readHolding(t, c, 1, 10); resp.LoggerSN() != virtual {
+		t.Fatalf("response serial: got [%d] want [%d]", resp.LoggerSN(), virtual)
+	}
+
+	// logger stick replaced
+	dialLogger(t, srv, replacement)
+	if !proxytest.WaitFor(timeout, func() bool { n, _, _ := srv.Counts(); return n == 2 }) {
+		t.Fatalf("loggers not registered")
+	}
+	old.SetHandler(func(*protocol.ModbusFrame) []byte { return nil })
+	srv.SetAlias(virtual, replacement)
+	if resp := readHolding(t, c, 2, 20); resp.LoggerSN() != virtual {
+		t.Fatalf("response serial: got [%d] want [%d]", resp.LoggerSN(), virtual)
+	}
+	if got := srv.Aliases()[virtual]; got != replacement {
+		t.Fatalf("alias: got [%d] want [%d]", got, replacement)
+	}
+}
+
+func TestAliasAPI(t *testing.T) {
+	do := func(t *testing.T, srv *proxytest.Server, method, path, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, "http://"+srv.DataAPIAddr().String()+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("read-only", func(t *testing.T) {
+		srv := newServer(t, proxytest.Options{DataAPI: true})
+		srv.SetAlias(100, serial)
+		if code := do(t, srv, http.MethodGet, "/aliases", ""); code != http.StatusOK {
+			t.Fatalf("GET /aliases: got [%d]", code)
+		}
+		if code := do(t, srv, http.MethodPut, "/aliases/100", "5"); code == http.StatusNoContent {
+			t.Fatalf("PUT /aliases accepted without alias editing")
+		}
+		if code := do(t, srv, http.MethodDelete, "/aliases/100", ""); code == http.StatusNoContent {
+			t.Fatalf("DELETE /aliases accepted without alias editing")
+		}
+		if got := srv.Aliases(); len(got) != 1 || got[100] != serial {
+			t.Fatalf("aliases: got %v", got)
+		}
+	})
+	t.Run("editing", func(t *testing.T) {
+		srv := newServer(t, proxytest.Options{DataAPI: true, AliasEditing: true})
+		if code := do(t, srv, http.MethodPut, "/aliases/100", "5"); code != http.StatusNoContent {
+			t.Fatalf("PUT /aliases: got [%d]", code)
+		}
+		if got := srv.Aliases(); len(got) != 1 || got[100] != 5 {
+			t.Fatalf("aliases after PUT: got %v", got)
+		}
+		if code := do(t, srv, http.MethodPut, "/aliases/101", "x"); code != http.StatusBadRequest {
+			t.Fatalf("PUT /aliases bad serial: got [%d]", code)
+		}
+		if code := do(t, srv, http.MethodDelete, "/aliases/100", ""); code != http.StatusNoContent {
+			t.Fatalf("DELETE /aliases: got [%d]", code)
+		}
+		if got := srv.Aliases(); len(got) != 0 {
+			t.Fatalf("aliases after DELETE: got %v", got)
+		}
+	})
+}
+
+func TestRuntimeBuffering(t *testing.T) {
+	srv := newServer(t, proxytest.Options{})
+	l := dialLogger(t, srv, serial)
+	c1 := dialClient(t, srv, serial)
+	c2 := dialClient(t, srv, serial)
+	readHolding(t, c1, 1, 1)
+	readHolding(t, c2, 1, 2)
+
+	// requests for register 1 are never answered
+	l.SetHandler(func(req *protocol.ModbusFrame) []byte {
+		if req.Address == 1 {
+			return nil
+		}
+		return proxytest.RegisterEcho(req)
+	})
+	srv.SetBuffering(true)
+	if err := c1.ReadHolding(2, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // c1 request must reach the logger first
+	if err := c2.ReadHolding(3, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c2.Next(200 * time.Millisecond); err == nil {
+		t.Fatalf("request not buffered, got response seq [%d]", resp.RequestSequence())
+	}
+
+	// the buffered request is sent after the next response
+	srv.SetBuffering(false)
+	readHolding(t, c2, 4, 3)
+	resp, err := c2.Next(timeout)
+	if err != nil {
+		t.Fatalf("buffered request lost: %v", err)
+	}
+	if resp.RequestSequence() != 3 {
+		t.Fatalf("sequence number: got [%d] want [3]", resp.RequestSequence())
+	}
+}
+
+func TestBufferingConcurrentClients(t *testing.T) {
+	srv := newServer(t, proxytest.Options{Buffering: true})
+	dialLogger(t, srv, serial)
+	clients := make([]*proxytest.FakeClient, 4)
+	for i := range clients {
+		clients[i] = dialClient(t, srv, serial)
+		readHolding(t, clients[i], 1, uint16(i))
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(base uint16, c *proxytest.FakeClient) {
+			defer wg.Done()
+			for n := uint16(0); n < 10; n++ {
+				if err := c.ReadHolding(n, base+n, 1); err != nil {
+					t.Errorf("client write: %v", err)
+					return
+				}
+			}
+			for n := uint16(0); n < 10; n++ {
+				resp, err := c.Next(timeout)
+				if err != nil {
+					t.Errorf("client [%d] response [%d]: %v", base, n, err)
+					return
+				}
+				m, err := resp.Modbus()
+				if err != nil || resp.RequestSequence() != byte(n) || m.Registers()[0] != base+n {
+					t.Errorf("client [%d]: got seq [%d] %v want seq [%d] register [%d]",
+						base, resp.RequestSequence(), m, n, base+n)
+					return
+				}
+			}
+		}(uint16(i*100), c)
+	}
+	wg.Wait()
+}
+
+func TestModbusTCPFrontend(t *testing.T) {
+	srv := newServer(t, proxytest.Options{Modbus: []server.ModbusListener{
+		{Route: client.ModbusRoute{Units: map[byte]uint32{5: serial}}},
+	}})
+	dialLogger(t, srv, serial)
+	conn, err := net.Dial("tcp", srv.ModbusAddrs()[0].String())
+	if err != nil {
+		t.Fatalf("modbus dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	for _, tc := range []struct {
+		tid  uint16
+		unit byte
+		want []byte
+	}{
+		{0x1234, 5, []byte{0x03, 0x02, 0x00, 0x0a}},
+		{0x4321, 9, protocol.ExceptionPDU(protocol.FuncReadHoldingRegisters, protocol.ExGatewayPathFailed)},
+	} {
+		req := &protocol.MBAPFrame{TransactionID: tc.tid, UnitID: tc.unit, PDU: []byte{0x03, 0x00, 0x0a, 0x00, 0x01}}
+		if _, err = conn.Write(req.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := protocol.ReadMBAP(conn)
+		if err != nil {
+			t.Fatalf("unit [%d] no response: %v", tc.unit, err)
+		}
+		if resp.TransactionID != tc.tid || resp.UnitID != tc.unit || !bytes.Equal(resp.PDU, tc.want) {
+			t.Fatalf("unit [%d] response: got tid [%04x] unit [%d] pdu [% x], want tid [%04x] pdu [% x]",
+				tc.unit, resp.TransactionID, resp.UnitID, resp.PDU, tc.tid, tc.want)
+		}
+	}
+}
+
+func TestModbusTCPUnitsOfSeveralLoggers(t *testing.T) {
+	const other = serial + 1
+	srv := newServer(t, proxytest.Options{Modbus: []server.ModbusListener{
+		{Route: client.ModbusRoute{Units: map[byte]uint32{5: serial, 6: other}}},
+	}})
+	dialLogger(t, srv, serial)
+	l, err := proxytest.DialLogger(srv.LoggersAddr().String(), other)
+	if err != nil {
+		t.Fatalf("logger dial: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	l.SetHandler(func(*protocol.ModbusFrame) []byte { return []byte{0x03, 0x02, 0xbe, 0xef} })
+	if !proxytest.WaitFor(timeout, func() bool { n, _, _ := srv.Counts(); return n == 2 }) {
+		t.Fatalf("logger [%d] not registered", other)
+	}
+
+	conn, err := net.Dial("tcp", srv.ModbusAddrs()[0].String())
+	if err != nil {
+		t.Fatalf("modbus dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	echo := []byte{0x03, 0x02, 0x00, 0x0a}
+	for i, tc := range []struct {
+		unit byte
+		want []byte
+	}{
+		{5, echo},
+		{6, []byte{0x03, 0x02, 0xbe, 0xef}},
+		{5, echo},
+		{9, protocol.ExceptionPDU(protocol.FuncReadHoldingRegisters, protocol.ExGatewayPathFailed)},
+		{6, []byte{0x03, 0x02, 0xbe, 0xef}},
+	} {
+		tid := uint16(0x100 + i)
+		req := &protocol.MBAPFrame{TransactionID: tid, UnitID: tc.unit, PDU: []byte{0x03, 0x00, 0x0a, 0x00, 0x01}}
+		if _, err = conn.Write(req.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := protocol.ReadMBAP(conn)
+		if err != nil {
+			t.Fatalf("unit [%d] no response: %v", tc.unit, err)
+		}
+		if resp.TransactionID != tid || resp.UnitID != tc.unit || !bytes.Equal(resp.PDU, tc.want) {
+			t.Fatalf("unit [%d] response: got tid [%04x] unit [%d] pdu [% x], want tid [%04x] pdu [% x]",
+				tc.unit, resp.TransactionID, resp.UnitID, resp.PDU, tid, tc.want)
+		}
+	}
+}
+
+func TestModbusRTUFrontend(t *testing.T) {
+	srv := newServer(t, proxytest.Options{Modbus: []server.ModbusListener{
+		{RTU: true, Route: client.ModbusRoute{Serial: serial}},
+	}})
+	dialLogger(t, srv, serial)
+	conn, err := net.Dial("tcp", srv.ModbusAddrs()[0].String())
+	if err != nil {
+		t.Fatalf("rtu dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// the request with bad CRC is skipped, only the valid one is answered
+	valid := protocol.AppendCRC([]byte{0x01, 0x03, 0x00, 0x14, 0x00, 0x01})
+	bad := append([]byte{}, valid...)
+	bad[len(bad)-1] ^= 0xff
+	if _, err = conn.Write(append(bad, valid...)); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, 7)
+	if _, err = io.ReadFull(conn, resp); err != nil {
+		t.Fatalf("no response: %v", err)
+	}
+	if !protocol.CRCValid(resp) || !bytes.Equal(resp[:5], []byte{0x01, 0x03, 0x02, 0x00, 0x14}) {
+		t.Fatalf("response: got [% x]", resp)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(resp); err == nil {
+		t.Fatalf("unexpected data: [% x]", resp[:n])
+	}
+}
+
+func TestPtyBridge(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pseudo-terminals are supported on Linux only")
+	}
+	dir := t.TempDir()
+	srv := newServer(t, proxytest.Options{PtyDir: dir})
+	dialLogger(t, srv, serial)
+
+	link := filepath.Join(dir, strconv.FormatUint(uint64(serial), 10))
+	if !proxytest.WaitFor(timeout, func() bool { _, err := os.Stat(link); return err == nil }) {
+		t.Fatalf("terminal link <%s> not created", link)
+	}
+	tty, err := os.OpenFile(link, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("terminal open: %v", err)
+	}
+	defer tty.Close()
+	if err = tty.SetDeadline(time.Now().Add(timeout)); err != nil {
+		t.Skipf("terminal deadlines not supported: %v", err)
+	}
+
+	// the link is created just before the bridge is bound to the logger, retried until answered
+	var resp []byte
+	if !proxytest.WaitFor(timeout, func() bool {
+		if _, err := tty.Write(protocol.AppendCRC([]byte{0x01, 0x03, 0x00, 0x1e, 0x00, 0x01})); err != nil {
+			t.Fatal(err)
+		}
+		resp = make([]byte, 7)
+		tty.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := io.ReadFull(tty, resp)
+		return err == nil
+	}) {
+		t.Fatalf("no response from the terminal")
+	}
+	if !protocol.CRCValid(resp) || !bytes.Equal(resp[:5], []byte{0x01, 0x03, 0x02, 0x00, 0x1e}) {
+		t.Fatalf("response: got [% x]", resp)
+	}
+}
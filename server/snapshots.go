@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+// dataSnapshot JSON representation of a data upload
+type dataSnapshot struct {
+	Serial      uint32    `json:"serial"`
+	Received    time.Time `json:"received"`
+	FrameType   byte      `json:"frame_type"`
+	SensorType  uint16    `json:"sensor_type"`
+	WorkingTime uint32    `json:"working_time"`
+	PowerOnTime uint32    `json:"power_on_time"`
+	OffsetTime  uint32    `json:"offset_time"`
+	Data        string    `json:"data"`
+	Registers   []uint16  `json:"registers"`
+}
+
+func newDataSnapshot(d *protocol.DataUpload) *dataSnapshot {
+	return &dataSnapshot{
+		Serial:      d.Serial,
+		Received:    d.Received,
+		FrameType:   d.FrameType,
+		SensorType:  d.SensorType,
+		WorkingTime: d.WorkingTime,
+		PowerOnTime: d.PowerOnTime,
+		OffsetTime:  d.OffsetTime,
+		Data:        hex.EncodeToString(d.Data),
+		Registers:   d.Registers(),
+	}
+}
+
+// EnableDataAPI - serve the captured data uploads and the serial aliases over HTTP on the given
+// address. The aliases can be edited only after EnableAliasEditing
+//
+//	GET    /data              all snapshots
+//	GET    /data/{serial}     the snapshot of a single data-logger
+//	GET    /aliases           the alias table (virtual -> physical serial)
+//	PUT    /aliases/{virtual} sets an alias, the body is the physical serial
+//	DELETE /aliases/{virtual} removes an alias
+func (s *V5ProxyServer) EnableDataAPI(addr string) {
+	s.dataAddr = addr
+}
+
+// DataAPIAddr - address of the data API listener (nil when disabled)
+func (s *V5ProxyServer) DataAPIAddr() net.Addr {
+	if s.dataL == nil {
+		return nil
+	}
+	return s.dataL.Addr()
+}
+
+// Snapshot - the last data upload of the data-logger with the given serial
+func (s *V5ProxyServer) Snapshot(serial uint32) (*protocol.DataUpload, bool) {
+	s.snapSync.RLock()
+	defer s.snapSync.RUnlock()
+	d, ok := s.snapshots[serial]
+	return d, ok
+}
+
+// Snapshots - the last data upload of every data-logger
+func (s *V5ProxyServer) Snapshots() []*protocol.DataUpload {
+	s.snapSync.RLock()
+	defer s.snapSync.RUnlock()
+	all := make([]*protocol.DataUpload, 0, len(s.snapshots))
+	for _, d := range s.snapshots {
+		all = append(all, d)
+	}
+	return all
+}
+
+func (s *V5ProxyServer) storeSnapshot(d *protocol.DataUpload) {
+	s.snapSync.Lock()
+	defer s.snapSync.Unlock()
+	s.snapshots[d.Serial] = d
+}
+
+// serveDataAPI HTTP server for the data snapshots and the alias table
+func (s *V5ProxyServer) serveDataAPI() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data", func(w http.ResponseWriter, r *http.Request) {
+		all := make([]*dataSnapshot, 0)
+		for _, d := range s.Snapshots() {
+			all = append(all, newDataSnapshot(d))
+		}
+		writeJSON(w, all)
+	})
+	mux.HandleFunc("GET /data/{serial}", func(w http.ResponseWriter, r *http.Request) {
+		serial, err := strconv.ParseUint(r.PathValue("serial"), 10, 32)
+		if err != nil {
+			http.Error(w, "bad serial number", http.StatusBadRequest)
+			return
+		}
+		d, ok := s.Snapshot(uint32(serial))
+		if !ok {
+			http.Error(w, "no data for logger", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, newDataSnapshot(d))
+	})
+
+	s.handleAliases(mux)
+
+	log.LogInfof("[Data-API] listening on <%s>\n", s.dataL.Addr().String())
+	err := http.Serve(s.dataL, mux)
+	if err != nil && !s.closed() {
+		log.LogErrorf("[Data-API] stopped: %s\n", err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
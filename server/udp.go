@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+	"github.com/githubDante/go-solarman-proxy/protocol"
+)
+
+const (
+	// udpSessionTimeout idle time after which a UDP client session is dropped
+	udpSessionTimeout = 2 * time.Minute
+	udpMaxDatagram    = 2048
+	udpQueueLen       = 8
+)
+
+// udpConn net.Conn of a single UDP client (datagram source address)
+//
+// Every datagram carries one V5 frame. The session is closed when idle for udpSessionTimeout
+type udpConn struct {
+	pc     net.PacketConn
+	remote net.Addr
+	in     chan []byte
+	buf    []byte
+	done   chan struct{}
+	once   sync.Once
+	// removes the session from the server
+	onClose func()
+
+	lock     sync.Mutex
+	deadline time.Time
+}
+
+func newUDPConn(pc net.PacketConn, remote net.Addr, onClose func()) *udpConn {
+	return &udpConn{
+		pc:      pc,
+		remote:  remote,
+		in:      make(chan []byte, udpQueueLen),
+		done:    make(chan struct{}),
+		onClose: onClose,
+	}
+}
+
+// deliver queues a datagram. Datagrams are dropped when the client is not keeping up
+func (u *udpConn) deliver(datagram []byte) {
+	select {
+	case u.in <- datagram:
+	default:
+		log.LogWarnf("UDP client [%s] queue full. Datagram dropped\n", u.remote.String())
+	}
+}
+
+func (u *udpConn) Read(b []byte) (int, error) {
+	if len(u.buf) == 0 {
+		timeout := udpSessionTimeout
+		u.lock.Lock()
+		if !u.deadline.IsZero() && time.Until(u.deadline) < timeout {
+			timeout = time.Until(u.deadline)
+		}
+		u.lock.Unlock()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case u.buf = <-u.in:
+		case <-u.done:
+			return 0, net.ErrClosed
+		case <-timer.C:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(b, u.buf)
+	u.buf = u.buf[n:]
+	return n, nil
+}
+
+func (u *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-u.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	return u.pc.WriteTo(b, u.remote)
+}
+
+func (u *udpConn) Close() error {
+	u.once.Do(func() {
+		close(u.done)
+		u.onClose()
+	})
+	return nil
+}
+
+func (u *udpConn) LocalAddr() net.Addr  { return u.pc.LocalAddr() }
+func (u *udpConn) RemoteAddr() net.Addr { return u.remote }
+
+func (u *udpConn) SetDeadline(t time.Time) error {
+	return u.SetReadDeadline(t)
+}
+
+func (u *udpConn) SetReadDeadline(t time.Time) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.deadline = t
+	return nil
+}
+
+func (u *udpConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// EnableUDP - starts a UDP listener for V5 clients. Every datagram must carry a single request,
+// the response is sent back to the source address
+func (s *V5ProxyServer) EnableUDP(addr string) {
+	s.udpAddr = addr
+}
+
+// UDPAddr - address of the UDP clients listener (nil when disabled)
+func (s *V5ProxyServer) UDPAddr() net.Addr {
+	if s.udpL == nil {
+		return nil
+	}
+	return s.udpL.LocalAddr()
+}
+
+// udpClientsConn Session manager for UDP clients
+//
+// A ClientSolarman is created for every new source address and registered as any TCP client
+func (s *V5ProxyServer) udpClientsConn() {
+	log.LogInfof("[Clients-Proxy] waiting for UDP clients on <%s>\n", s.udpL.LocalAddr().String())
+	buf := make([]byte, udpMaxDatagram)
+	for {
+		n, addr, err := s.udpL.ReadFrom(buf)
+		if err != nil {
+			if s.closed() || errors.Is(err, io.EOF) {
+				return
+			}
+			log.LogErrorf("UDP client read error: %s\n", err.Error())
+			continue
+		}
+		if _, err = protocol.NewV5Frame(buf[:n]); err != nil {
+			log.LogWarnf("UDP client [%s] datagram dropped: %s\n", addr.String(), err.Error())
+			continue
+		}
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+
+		key := addr.String()
+		s.udpSync.Lock()
+		conn, ok := s.udpSessions[key]
+		if !ok {
+			conn = newUDPConn(s.udpL, addr, func() {
+				s.udpSync.Lock()
+				delete(s.udpSessions, key)
+				s.udpSync.Unlock()
+			})
+			s.udpSessions[key] = conn
+		}
+		s.udpSync.Unlock()
+		conn.deliver(datagram)
+		if !ok {
+			cl := client.NewSolarmanClient(conn, s.clientsComm, s.broadcastComm)
+			log.LogInfof("New UDP solarman client [%s]\n", key)
+			s.registerClient(cl)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// UnixListener V5 clients listener on a unix domain socket
+type UnixListener struct {
+	Path string
+	// Socket file mode (0 - keep the mode set by the umask)
+	Mode fs.FileMode
+	// Socket file owner and group, names or numeric ids (empty - keep the current ones)
+	Owner string
+	Group string
+
+	listener net.Listener
+}
+
+// EnableUnix - accepts V5 clients on a unix domain socket. The routing is the same as for
+// the TCP clients. Must be called before Serve
+func (s *V5ProxyServer) EnableUnix(l UnixListener) {
+	s.unix = &l
+}
+
+// UnixAddr - address of the unix socket clients listener (nil when disabled)
+func (s *V5ProxyServer) UnixAddr() net.Addr {
+	if s.unix == nil || s.unix.listener == nil {
+		return nil
+	}
+	return s.unix.listener.Addr()
+}
+
+// listen creates the socket. A stale socket file left by a previous run is removed
+func (u *UnixListener) listen() error {
+	if fi, err := os.Lstat(u.Path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return errors.New(u.Path + " exists and is not a socket")
+		}
+		if err = os.Remove(u.Path); err != nil {
+			return err
+		}
+	}
+	l, err := net.Listen("unix", u.Path)
+	if err != nil {
+		return err
+	}
+	if u.Mode != 0 {
+		if err = os.Chmod(u.Path, u.Mode); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	if u.Owner != "" || u.Group != "" {
+		if err = u.chown(); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	u.listener = l
+	return nil
+}
+
+// chown changes the owner and/or the group of the socket file
+func (u *UnixListener) chown() error {
+	uid, gid := -1, -1
+	if u.Owner != "" {
+		usr, err := user.Lookup(u.Owner)
+		if err != nil {
+			usr, err = user.LookupId(u.Owner)
+		}
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(usr.Uid); err != nil {
+			return err
+		}
+	}
+	if u.Group != "" {
+		grp, err := user.LookupGroup(u.Group)
+		if err != nil {
+			grp, err = user.LookupGroupId(u.Group)
+		}
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(grp.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chown(u.Path, uid, gid)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/githubDante/go-solarman-proxy/client"
+	log "github.com/githubDante/go-solarman-proxy/logging"
+)
+
+// UnknownSerialMode handling of clients requesting serial 0 or serial of a logger which was never
+// connected to the proxy (e.g. mistyped). Clients of disconnected loggers wait for them in all modes
+type UnknownSerialMode int
+
+const (
+	// UnknownBroadcast requests are written to all loggers with unknown serial
+	UnknownBroadcast UnknownSerialMode = iota
+	// UnknownDefault clients are bound to the default logger
+	UnknownDefault
+	// UnknownReject requests are answered with Modbus exception 0x0a (gateway path unavailable)
+	UnknownReject
+	// UnknownPending clients wait for their logger, requests are dropped
+	UnknownPending
+)
+
+func (m UnknownSerialMode) String() string {
+	switch m {
+	case UnknownBroadcast:
+		return "broadcast"
+	case UnknownDefault:
+		return "default"
+	case UnknownReject:
+		return "reject"
+	case UnknownPending:
+		return "pending"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(m))
+	}
+}
+
+// ParseUnknownSerialMode mode by name (broadcast, default, reject, pending)
+func ParseUnknownSerialMode(name string) (UnknownSerialMode, error) {
+	for m := UnknownBroadcast; m <= UnknownPending; m++ {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return UnknownBroadcast, fmt.Errorf("bad unknown serial mode <%s>, expected broadcast, default, reject or pending", name)
+}
+
+// RouteUnknownSerials - sets the handling of clients with serial 0 or serial of a logger which was
+// never connected. defaultLogger is used by UnknownDefault only. Must be called before Serve
+func (s *V5ProxyServer) RouteUnknownSerials(mode UnknownSerialMode, defaultLogger uint32) {
+	s.unknownMode = mode
+	s.defaultLogger = defaultLogger
+	if mode == UnknownDefault {
+		log.LogInfof("[Proxy] clients with unknown serial will be routed to logger [%d]\n", defaultLogger)
+	} else {
+		log.LogInfof("[Proxy] unknown serials mode [%s]\n", mode)
+	}
+}
+
+// unroutedPolicy handling of the requests from clients without logger
+func (s *V5ProxyServer) unroutedPolicy() client.UnroutedPolicy {
+	switch s.unknownMode {
+	case UnknownReject:
+		return client.UnroutedReject
+	case UnknownDefault, UnknownPending:
+		return client.UnroutedDrop
+	default:
+		return client.UnroutedBroadcast
+	}
+}
+
+// routeUnknown handles a client which reported an unknown serial
+//
+// Must be called with mapSync held
+func (s *V5ProxyServer) routeUnknown(cl *client.ClientSolarman, serial uint32) {
+	addr := cl.Conn.RemoteAddr().String()
+	switch s.unknownMode {
+	case UnknownDefault:
+		if cl.Pinned() {
+			log.LogWarnf("Client [%s] logger [%d] not connected yet. Dedicated client kept pending\n", addr, serial)
+			return
+		}
+		logger, ok := s.loggers[s.defaultLogger]
+		if !ok || !logger.Running() {
+			log.LogWarnf("Client [%s] serial [%d] unknown. Default logger [%d] not connected, client kept pending\n",
+				addr, serial, s.defaultLogger)
+			return
+		}
+		if cl.Logger() == logger {
+			return
+		}
+		log.LogWarnf("Client [%s] serial [%d] unknown. Routed to default logger [%d]\n", addr, serial, s.defaultLogger)
+		logger.Add(cl)
+		cl.AddLogger(logger)
+		delete(s.pending, cl.Id)
+	case UnknownReject:
+		log.LogWarnf("Client [%s] serial [%d] unknown. Requests rejected\n", addr, serial)
+	case UnknownPending:
+		log.LogWarnf("Client [%s] serial [%d] unknown. Client kept pending\n", addr, serial)
+	default:
+		log.LogWarnf("Client [%s] serial [%d] unknown. Requests broadcast to loggers with unknown serial\n", addr, serial)
+	}
+}
+
+// reclaimFromDefault moves the clients routed to the default logger while their own logger was
+// unknown to the freshly connected logger
+//
+// Must be called with mapSync held
+func (s *V5ProxyServer) reclaimFromDefault(logger *client.ClientLogger) {
+	if s.unknownMode != UnknownDefault || logger.Serial == s.defaultLogger {
+		return
+	}
+	def, ok := s.loggers[s.defaultLogger]
+	if !ok {
+		return
+	}
+	for _, cl := range def.Associated() {
+		if cl.Identified() && s.resolveSerial(cl.Serial) == logger.Serial {
+			log.LogInfof("Client [%s] moved from default logger [%d] to logger [%d]\n",
+				cl.Conn.RemoteAddr().String(), s.defaultLogger, logger.Serial)
+			def.Remove(cl)
+			logger.Add(cl)
+			cl.AddLogger(logger)
+		}
+	}
+}
+
+// isDefaultLogger reports whether the pending clients with unknown serials should be bound to the logger
+func (s *V5ProxyServer) isDefaultLogger(serial uint32) bool {
+	return s.unknownMode == UnknownDefault && serial == s.defaultLogger
+}